@@ -0,0 +1,233 @@
+package gosocketio
+
+import (
+	"sync"
+
+	"github.com/mtfelian/golang-socketio/logging"
+	"github.com/mtfelian/golang-socketio/protocol"
+)
+
+// Adapter manages rooms of the namespace channels and broadcasts to them,
+// implementations should be safe for concurrent use
+type Adapter interface {
+	// Join adds the channel c to the room
+	Join(c *Channel, room string)
+	// Leave removes the channel c from the room
+	Leave(c *Channel, room string)
+	// LeaveAll removes the channel c from all the rooms, it's called when the channel disconnects
+	LeaveAll(c *Channel)
+	// Rooms returns names of the rooms the channel c is joined to
+	Rooms(c *Channel) []string
+	// Broadcast the event with the given name and payload to the channels selected by opts
+	Broadcast(opts BroadcastOptions, name string, payload interface{})
+	// Amount returns an amount of channels joined to the room
+	Amount(room string) int
+	// List returns channels joined to the room
+	List(room string) []*Channel
+	// CountRooms returns an amount of rooms with at least one joined channel
+	CountRooms() int
+}
+
+// BroadcastOptions selects the namespace channels to broadcast to
+type BroadcastOptions struct {
+	Rooms     []string // broadcast to the channels joined to any of the rooms, to all the channels if empty
+	Except    []string // skip the channels joined to any of the rooms
+	ExceptIDs []string // skip the channels with the ids
+	Volatile  bool     // drop the event for the channels not writable at the moment
+}
+
+// NewAdapterFunc creates an adapter for the namespace n
+type NewAdapterFunc func(n *Namespace) Adapter
+
+// MemoryAdapter is the default adapter keeping rooms in memory, it reaches the local channels only
+type MemoryAdapter struct {
+	namespace *Namespace
+
+	channels map[string]map[*Channel]struct{} // maps room name to map of channels to an empty struct
+	rooms    map[*Channel]map[string]struct{} // maps channel to map of room names to an empty struct
+	mu       sync.RWMutex
+}
+
+// newDefaultAdapter creates the default in-memory adapter for the namespace n
+func newDefaultAdapter(n *Namespace) Adapter { return NewMemoryAdapter(n) }
+
+// NewMemoryAdapter creates the in-memory adapter for the namespace n
+func NewMemoryAdapter(n *Namespace) *MemoryAdapter {
+	return &MemoryAdapter{
+		namespace: n,
+		channels:  make(map[string]map[*Channel]struct{}),
+		rooms:     make(map[*Channel]map[string]struct{}),
+	}
+}
+
+// Join adds the channel c to the room
+func (a *MemoryAdapter) Join(c *Channel, room string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.channels[room]; !ok {
+		a.channels[room] = make(map[*Channel]struct{})
+	}
+
+	if _, ok := a.rooms[c]; !ok {
+		a.rooms[c] = make(map[string]struct{})
+	}
+
+	a.channels[room][c], a.rooms[c][room] = struct{}{}, struct{}{}
+}
+
+// Leave removes the channel c from the room
+func (a *MemoryAdapter) Leave(c *Channel, room string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.leave(c, room)
+}
+
+// leave removes the channel c from the room, a.mu should be locked
+func (a *MemoryAdapter) leave(c *Channel, room string) {
+	if roomChannels, ok := a.channels[room]; ok {
+		delete(roomChannels, c)
+		if len(roomChannels) == 0 {
+			delete(a.channels, room)
+		}
+	}
+
+	if channelRooms, ok := a.rooms[c]; ok {
+		delete(channelRooms, room)
+		if len(channelRooms) == 0 {
+			delete(a.rooms, c)
+		}
+	}
+}
+
+// LeaveAll removes the channel c from all the rooms
+func (a *MemoryAdapter) LeaveAll(c *Channel) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for room := range a.rooms[c] {
+		a.leave(c, room)
+	}
+}
+
+// Rooms returns names of the rooms the channel c is joined to
+func (a *MemoryAdapter) Rooms(c *Channel) []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	rooms := make([]string, 0, len(a.rooms[c]))
+	for room := range a.rooms[c] {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+// Broadcast the event with the given name and payload to the local channels selected by opts.
+// The packet is encoded once and queued to every channel in order, unless the namespace has outbound
+// interceptors which need to see every channel. Broadcasts never wait for room in the channel queues.
+func (a *MemoryAdapter) Broadcast(opts BroadcastOptions, name string, payload interface{}) {
+	intercepted := a.namespace.hasOutbound()
+
+	var packets []string
+	if !intercepted {
+		m := &protocol.Message{Type: protocol.MessageTypeEmit, Namespace: a.namespace.name, EventName: name}
+		var err error
+		if packets, err = encodePackets(m, payload); err != nil {
+			a.namespace.server.log().Warn("MemoryAdapter.Broadcast() failed to encode",
+				logging.F(logging.KeyEvent, name), logging.Err(err))
+			return
+		}
+	}
+
+	for _, c := range a.Select(opts) {
+		if !c.IsAlive() {
+			continue
+		}
+		if intercepted {
+			var err error
+			if packets, err = c.encodeEvent(name, payload); err != nil {
+				c.log().Debug("MemoryAdapter.Broadcast() skips channel", logging.F(logging.KeyEvent, name), logging.Err(err))
+				continue
+			}
+		}
+		c.enqueueBroadcast(name, packets, opts.Volatile)
+	}
+}
+
+// Select returns the local channels selected by opts
+func (a *MemoryAdapter) Select(opts BroadcastOptions) []*Channel {
+	var candidates []*Channel
+	if len(opts.Rooms) == 0 {
+		candidates = a.namespace.channelsList()
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	selected := make(map[*Channel]struct{})
+	for _, c := range candidates {
+		selected[c] = struct{}{}
+	}
+	for _, room := range opts.Rooms {
+		for c := range a.channels[room] {
+			selected[c] = struct{}{}
+		}
+	}
+
+	for _, room := range opts.Except {
+		for c := range a.channels[room] {
+			delete(selected, c)
+		}
+	}
+
+	except := make(map[string]struct{}, len(opts.ExceptIDs))
+	for _, id := range opts.ExceptIDs {
+		except[id] = struct{}{}
+	}
+
+	channels := make([]*Channel, 0, len(selected))
+	for c := range selected {
+		if _, ok := except[c.Id()]; !ok {
+			channels = append(channels, c)
+		}
+	}
+	return channels
+}
+
+// Amount returns an amount of local channels joined to the room
+func (a *MemoryAdapter) Amount(room string) int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.channels[room])
+}
+
+// List returns local channels joined to the room
+func (a *MemoryAdapter) List(room string) []*Channel {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	roomChannels := make([]*Channel, 0, len(a.channels[room]))
+	for c := range a.channels[room] {
+		roomChannels = append(roomChannels, c)
+	}
+	return roomChannels
+}
+
+// RoomNames returns names of the rooms with at least one joined local channel
+func (a *MemoryAdapter) RoomNames() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	rooms := make([]string, 0, len(a.channels))
+	for room := range a.channels {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+// CountRooms returns an amount of rooms with at least one joined local channel
+func (a *MemoryAdapter) CountRooms() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.channels)
+}
@@ -0,0 +1,65 @@
+package gosocketio
+
+// BroadcastOperator selects the namespace channels to broadcast to, it's methods may be chained:
+//
+//	c.To("a", "b").Except("muted").Broadcast().Emit("message", payload)
+//
+// Channels joined to several of the selected rooms receive the event once.
+type BroadcastOperator struct {
+	namespace *Namespace
+	opts      BroadcastOptions
+}
+
+// newBroadcastOperator creates the operator broadcasting to all the channels of the namespace n,
+// except the channels with the given ids
+func newBroadcastOperator(n *Namespace, exceptIDs ...string) *BroadcastOperator {
+	return &BroadcastOperator{namespace: n, opts: BroadcastOptions{ExceptIDs: exceptIDs}}
+}
+
+// To returns the operator broadcasting to the channels joined to any of the given rooms as well
+func (o *BroadcastOperator) To(rooms ...string) *BroadcastOperator {
+	opts := o.opts
+	opts.Rooms = append(append([]string{}, o.opts.Rooms...), rooms...)
+	return &BroadcastOperator{namespace: o.namespace, opts: opts}
+}
+
+// Except returns the operator skipping the channels joined to any of the given rooms as well
+func (o *BroadcastOperator) Except(rooms ...string) *BroadcastOperator {
+	opts := o.opts
+	opts.Except = append(append([]string{}, o.opts.Except...), rooms...)
+	return &BroadcastOperator{namespace: o.namespace, opts: opts}
+}
+
+// Broadcast returns the operator itself, it follows socket.io socket.broadcast flag:
+// the operators created by the channel always skip it
+func (o *BroadcastOperator) Broadcast() *BroadcastOperator { return o }
+
+// Emit the event with the given name and payload to the selected channels
+func (o *BroadcastOperator) Emit(name string, payload interface{}) error {
+	if o.namespace == nil {
+		return ErrorServerNotSet
+	}
+
+	o.namespace.Adapter().Broadcast(o.opts, name, payload)
+	return nil
+}
+
+// To returns the operator broadcasting to the channels of the namespace joined to any of the given rooms
+func (n *Namespace) To(rooms ...string) *BroadcastOperator {
+	return newBroadcastOperator(n).To(rooms...)
+}
+
+// Except returns the operator broadcasting to the channels of the namespace
+// not joined to any of the given rooms
+func (n *Namespace) Except(rooms ...string) *BroadcastOperator {
+	return newBroadcastOperator(n).Except(rooms...)
+}
+
+// Broadcast returns the operator broadcasting to all the channels of the namespace except channel c
+func (c *Channel) Broadcast() *BroadcastOperator { return newBroadcastOperator(c.namespace, c.Id()) }
+
+// To returns the operator broadcasting to the channels joined to any of the given rooms except channel c
+func (c *Channel) To(rooms ...string) *BroadcastOperator { return c.Broadcast().To(rooms...) }
+
+// Except returns the operator broadcasting to the channels not joined to any of the given rooms except channel c
+func (c *Channel) Except(rooms ...string) *BroadcastOperator { return c.Broadcast().Except(rooms...) }
@@ -0,0 +1,125 @@
+package gosocketio
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mtfelian/golang-socketio/logging"
+	"github.com/mtfelian/golang-socketio/protocol"
+)
+
+// nopConnection is the transport connection discarding the written messages
+type nopConnection struct{}
+
+func (nopConnection) GetMessage() (string, error)                   { return "", ErrorClientNotConnected }
+func (nopConnection) WriteMessage(string) error                     { return nil }
+func (nopConnection) Close() error                                  { return nil }
+func (nopConnection) PingParams() (interval, timeout time.Duration) { return time.Minute, time.Minute }
+
+// newTestChannels creates the server with the given amount of channels connected to the default namespace
+// and joined to the room "room". The channels have no outgoing loop, their packets stay in the outgoing queues.
+func newTestChannels(amount int, queue QueueParams) (*Server, []*Channel) {
+	s := NewServer()
+	s.SetLogger(logging.Nop())
+	s.SetQueue(queue)
+
+	channels := make([]*Channel, amount)
+	for i := range channels {
+		c := s.newChannel(httptest.NewRequest("GET", "/socket.io/?EIO=3&transport=websocket", nil))
+		c.conn = nopConnection{}
+		onConnection(c)
+		c.Join("room")
+		channels[i] = c
+	}
+	return s, channels
+}
+
+// drain the outgoing queues of the channels
+func drain(channels []*Channel) {
+	for _, c := range channels {
+		for len(c.outC) > 0 {
+			<-c.outC
+		}
+	}
+}
+
+// queuedEvents returns the args of the event packets queued to the channel c with the given name
+func queuedEvents(t *testing.T, c *Channel, name string) []string {
+	var args []string
+	for len(c.outC) > 0 {
+		packets := <-c.outC
+		m, err := protocol.Decode(packets[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.EventName == name {
+			args = append(args, m.Args)
+		}
+	}
+	return args
+}
+
+func TestBroadcastOrder(t *testing.T) {
+	for _, intercepted := range []bool{false, true} {
+		s, channels := newTestChannels(10, QueueParams{Size: 1000})
+		if intercepted {
+			s.InterceptOutbound(func(c *Channel, name string, payload interface{}) (interface{}, error) {
+				return payload, nil
+			})
+		}
+
+		for i := 0; i < 100; i++ {
+			s.BroadcastTo("room", "seq", i)
+		}
+
+		for _, c := range channels {
+			args := queuedEvents(t, c, "seq")
+			if len(args) != 100 {
+				t.Fatalf("intercepted %v: got %d events, want 100", intercepted, len(args))
+			}
+			for i, arg := range args {
+				if arg != strconv.Itoa(i) {
+					t.Fatalf("intercepted %v: got event %s at position %d", intercepted, arg, i)
+				}
+			}
+		}
+	}
+}
+
+type benchmarkPayload struct {
+	ID    int      `json:"id"`
+	Text  string   `json:"text"`
+	Tags  []string `json:"tags"`
+	Score float64  `json:"score"`
+}
+
+var broadcastPayload = benchmarkPayload{ID: 1, Text: "the quick brown fox jumps over the lazy dog",
+	Tags: []string{"a", "b", "c"}, Score: 0.5}
+
+// BenchmarkEmitPerChannel emits to every channel of the room in turn, encoding the packet for every channel
+func BenchmarkEmitPerChannel(b *testing.B) {
+	s, channels := newTestChannels(1000, QueueParams{Size: 10})
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for _, c := range s.List("room") {
+			c.Emit("message", broadcastPayload)
+		}
+		drain(channels)
+	}
+}
+
+// BenchmarkBroadcast broadcasts to the room, encoding the packet once
+func BenchmarkBroadcast(b *testing.B) {
+	s, channels := newTestChannels(1000, QueueParams{Size: 10})
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		s.BroadcastTo("room", "message", broadcastPayload)
+		drain(channels)
+	}
+}
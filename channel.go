@@ -1,8 +1,10 @@
 package gosocketio
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"sync"
 	"time"
@@ -10,11 +12,13 @@ import (
 	"github.com/mtfelian/golang-socketio/logging"
 	"github.com/mtfelian/golang-socketio/protocol"
 	"github.com/mtfelian/golang-socketio/transport"
+	"github.com/mtfelian/synced"
 )
 
 const (
 	queueBufferSize = 500
 	headerForward   = "X-Forwarded-For"
+	messageStop     = "stop" // stops the outgoing loop, never sent
 )
 
 var (
@@ -28,38 +32,191 @@ type connectionHeader struct {
 	Upgrades     []string `json:"upgrades"`
 	PingInterval int      `json:"pingInterval"`
 	PingTimeout  int      `json:"pingTimeout"`
+	MaxPayload   int64    `json:"maxPayload,omitempty"`
 }
 
 // Channel represents socket.io connection
 type Channel struct {
-	conn transport.Connection
-
-	outC       chan string
-	stubC      chan string
-	upgradedC  chan string
+	conn      transport.Connection
+	upgrading bool           // true while the transport is being upgraded
+	writeMu   sync.Mutex     // locked while the outgoing loop writes, the client upgrade waits for the write in-flight
+	logger    logging.Logger // nil for the default logger
+	logEntry  logging.Entry  // logs with the sid and the transport fields
+	connMu    sync.RWMutex
+
+	outC       chan []string // queued packets, every packet is followed by it's binary attachments
+	outDoneC   chan struct{} // closed when the outgoing loop ends
+	outHead    [][]string    // control packets taken from the queue to drop the oldest event, written first
+	outLockC   chan struct{} // holds a token while the queue is read with the drop oldest policy
+	connectC   chan error    // receives the default namespace connection result at the client, protocol v4 only
 	connHeader connectionHeader
+	eio        int    // engine.io protocol version
+	auth       string // auth payload of the namespace CONNECT packet, protocol v4 only
 
 	alive   bool
 	aliveMu sync.Mutex
+	ctx     context.Context // cancelled when the channel is closed
+	cancel  context.CancelFunc
 
 	ack *acks
 
-	server  *Server
-	address string
-	header  http.Header
+	queue           QueueParams // outgoing queue params, the namespace channels share the queue of the root channel
+	volatileDropped synced.Counter
+
+	dispatcher *dispatcher // handles the incoming events in order, nil to handle every event in it's own goroutine
+	inQueue    inQueue     // incoming events queued to the dispatcher
+
+	server    *Server
+	namespace *Namespace
+	events    *event
+	address   string
+	header    http.Header
+
+	nsp    string              // namespace name, empty for the root channel
+	parent *Channel            // root channel for the namespace channel, nil for the root channel
+	nsps   map[string]*Channel // maps namespace name to namespace channels multiplexed over the root channel
+	nspsMu sync.RWMutex
 }
 
 // init the Channel
 func (c *Channel) init() {
-	c.outC, c.stubC, c.upgradedC = make(chan string, queueBufferSize), make(chan string), make(chan string)
+	if c.queue.Size <= 0 {
+		c.queue.Size = queueBufferSize
+	}
+	c.outC, c.outDoneC, c.connectC = make(chan []string, c.queue.Size), make(chan struct{}), make(chan error, 1)
+	c.outLockC = make(chan struct{}, 1)
 	c.ack = &acks{}
 	c.ack.ackC = make(map[int]chan string)
+	c.nsps = make(map[string]*Channel)
 	c.alive = true
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+}
+
+// addNamespaceChannel creates a channel for the namespace with the given name and handlers e
+// multiplexed over the root channel c
+func (c *Channel) addNamespaceChannel(name string, e *event) *Channel {
+	nc := &Channel{
+		outC:       c.outC,
+		connHeader: c.connHeader,
+		eio:        c.eio,
+		ack:        &acks{ackC: make(map[int]chan string)},
+		alive:      true,
+		server:     c.server,
+		queue:      c.queue,
+		dispatcher: c.dispatcher,
+		events:     e,
+		address:    c.address,
+		header:     c.header,
+		nsp:        name,
+		parent:     c,
+	}
+	nc.ctx, nc.cancel = context.WithCancel(c.ctx)
+
+	c.nspsMu.Lock()
+	c.nsps[name] = nc
+	c.nspsMu.Unlock()
+	return nc
+}
+
+// connection returns the current transport connection of the channel
+func (c *Channel) connection() transport.Connection {
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+	return c.conn
+}
+
+// upgradeConnection replaces the transport connection from of the channel with the upgraded one to,
+// the caller is responsible for stopping the previous connection.
+// Returns false if the channel is already closed or it's connection is not from.
+func (c *Channel) upgradeConnection(from, to transport.Connection) bool {
+	c.aliveMu.Lock()
+	defer c.aliveMu.Unlock()
+
+	if !c.alive {
+		return false
+	}
+
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	if c.conn != from {
+		return false
+	}
+	c.conn = to
+	c.updateLog()
+	return true
+}
+
+// log returns the log entry of the channel with the sid and the transport fields
+func (c *Channel) log() logging.Entry {
+	root := c.root()
+	root.connMu.RLock()
+	defer root.connMu.RUnlock()
+	return root.logEntry
+}
+
+// setLogger sets the logger l of the root channel c
+func (c *Channel) setLogger(l logging.Logger) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	c.logger = l
+	c.updateLog()
+}
+
+// updateLog updates the log entry fields of the root channel c with it's sid and transport, connMu should be locked
+func (c *Channel) updateLog() {
+	c.logEntry = logging.NewEntry(c.logger,
+		logging.F(logging.KeySid, c.connHeader.Sid), logging.F(logging.KeyTransport, transport.Name(c.conn)))
+}
+
+// root returns the root channel the namespace channel c is multiplexed over, or c itself for the root channel
+func (c *Channel) root() *Channel {
+	if c.parent != nil {
+		return c.parent
+	}
+	return c
+}
+
+// namespaceChannel returns the namespace channel with the given name multiplexed over the root channel c,
+// the second parameter is true if such channel found.
+func (c *Channel) namespaceChannel(name string) (*Channel, bool) {
+	c.nspsMu.RLock()
+	nc, ok := c.nsps[name]
+	c.nspsMu.RUnlock()
+	return nc, ok
 }
 
 // Id returns an ID of the current socket connection
 func (c *Channel) Id() string { return c.connHeader.Sid }
 
+// Namespace returns a name of the namespace the channel is connected to
+func (c *Channel) Namespace() string { return namespaceName(c.nsp) }
+
+// Auth returns a JSON auth payload sent by the client connecting to the namespace, empty for protocol v3 clients
+func (c *Channel) Auth() string { return c.auth }
+
+// ProtocolVersion returns an engine.io protocol version of the connection
+func (c *Channel) ProtocolVersion() int { return c.eio }
+
+// connectPayload returns a payload of the namespace CONNECT packet sent to the client
+func (c *Channel) connectPayload() interface{} {
+	if c.eio != transport.ProtocolVersion4 {
+		return nil
+	}
+	return map[string]string{"sid": c.Id()}
+}
+
+// sendConnectError sends the error with the given message for connecting to the namespace nsp over channel c
+func (c *Channel) sendConnectError(nsp, message string) error {
+	var payload interface{} = message
+	if c.eio == transport.ProtocolVersion4 {
+		payload = map[string]string{"message": message}
+	}
+	return c.send(&protocol.Message{Type: protocol.MessageTypeError, Namespace: nsp}, payload)
+}
+
+// Context returns the channel context, it's cancelled when the channel is closed
+func (c *Channel) Context() context.Context { return c.ctx }
+
 // IsAlive checks that Channel is still alive
 func (c *Channel) IsAlive() bool {
 	c.aliveMu.Lock()
@@ -67,19 +224,13 @@ func (c *Channel) IsAlive() bool {
 	return c.alive
 }
 
-// Close the client (Channel) connection
-func (c *Channel) Close() error { return c.close(c.server.event) }
-
-// stub closes the polling client (Channel) connection at socket.io upgrade
-func (c *Channel) stub() error { return c.close(nil) }
+// Close the client (Channel) connection, for the namespace channel only disconnects from the namespace
+func (c *Channel) Close() error { return c.close(c.events, c.localDisconnect()) }
 
-// close channel
-func (c *Channel) close(e *event) error {
-	switch c.conn.(type) {
-	case *transport.PollingConnection:
-		logging.Log().Debug("Channel.close() type: PollingConnection")
-	case *transport.WebsocketConnection:
-		logging.Log().Debug("Channel.close() type: WebsocketConnection")
+// close channel for the given reason
+func (c *Channel) close(e *event, reason DisconnectReason) error {
+	if c.parent != nil {
+		return c.closeNamespace(e, true, reason)
 	}
 
 	c.aliveMu.Lock()
@@ -89,175 +240,451 @@ func (c *Channel) close(e *event) error {
 		return nil
 	}
 
-	c.conn.Close()
+	c.log().Debug("Channel.close() fired", logging.F("reason", reason))
+	c.connection().Close()
 	c.alive = false
+	c.cancel()
+	c.connected(ErrorClientNotConnected) // release the client waiting for the connection
+
+	c.nspsMu.RLock()
+	nspChannels := make([]*Channel, 0, len(c.nsps))
+	for _, nc := range c.nsps {
+		nspChannels = append(nspChannels, nc)
+	}
+	c.nspsMu.RUnlock()
+
+	for _, nc := range nspChannels {
+		nc.closeNamespace(nc.events, false, reason)
+	}
 
 	// clean outloop
 	for len(c.outC) > 0 {
 		<-c.outC
 	}
 
-	if e != nil { // close
-		c.outC <- protocol.MessageClose
-		e.callHandler(c, OnDisconnection)
-	} else { // stub at transport upgrade
-		c.outC <- protocol.MessageStub
+	c.outC <- []string{messageStop}
+	if e != nil {
+		e.callDisconnection(c, reason)
 	}
 
-	overfloodedMu.Lock()
-	delete(overflooded, c)
-	overfloodedMu.Unlock()
+	if c.server != nil {
+		c.server.setOverflooding(c, false)
+		c.server.metrics.disconnects.Inc(string(reason))
+	}
 
 	return nil
 }
 
+// closeNamespace disconnects the namespace channel c from its namespace for the given reason,
+// if notify is true the other side receives a disconnect packet
+func (c *Channel) closeNamespace(e *event, notify bool, reason DisconnectReason) error {
+	c.aliveMu.Lock()
+	if !c.alive { // already closed
+		c.aliveMu.Unlock()
+		return nil
+	}
+	c.alive = false
+	c.cancel()
+	c.aliveMu.Unlock()
+
+	c.parent.nspsMu.Lock()
+	delete(c.parent.nsps, c.nsp)
+	c.parent.nspsMu.Unlock()
+
+	if notify { // aliveMu is unlocked, the queue policy may wait for room
+		c.send(&protocol.Message{Type: protocol.MessageTypeDisconnect}, nil)
+	}
+
+	if e != nil {
+		e.callDisconnection(c, reason)
+	}
+	return nil
+}
+
+// connected notifies the client waiting for the default namespace connection with the result err
+func (c *Channel) connected(err error) {
+	select {
+	case c.connectC <- err:
+	default:
+	}
+}
+
+// processNamespaced processes an incoming message m addressed to the non-default namespace over the root channel c
+func (c *Channel) processNamespaced(m *protocol.Message) {
+	nc, ok := c.namespaceChannel(m.Namespace)
+
+	switch m.Type {
+	case protocol.MessageTypeEmpty:
+		if c.server != nil {
+			c.server.connectNamespace(c, m.Namespace, m.Args)
+			return
+		}
+		if ok {
+			nc.events.callHandler(nc, OnConnection)
+		}
+	case protocol.MessageTypeDisconnect, protocol.MessageTypeError:
+		if ok {
+			nc.closeNamespace(nc.events, false, c.remoteDisconnect())
+		}
+	default:
+		if ok {
+			nc.dispatch(nc.events, m)
+		}
+	}
+}
+
+// dispatch the incoming message m to the handlers e in a separate goroutine, or to the dispatcher if it's set,
+// the server tracks the event handlers in-flight and drops incoming events while shutting down
+func (c *Channel) dispatch(e *event, m *protocol.Message) {
+	isEvent := m.Type == protocol.MessageTypeEmit || m.Type == protocol.MessageTypeAckRequest
+	if !isEvent {
+		go e.processIncoming(c, m)
+		return
+	}
+	c.countReceived(e, m)
+
+	handle := func() { e.processIncoming(c, m) }
+	if c.server != nil {
+		if !c.server.handlerStarted() {
+			c.log().Debug("Channel.dispatch() dropped event at server shutdown", logging.F(logging.KeyEvent, m.EventName))
+			return
+		}
+
+		handle = func() {
+			defer c.server.handlers.Done()
+			e.processIncoming(c, m)
+		}
+	}
+
+	if c.dispatcher != nil {
+		c.dispatcher.dispatch(c, handle)
+		return
+	}
+	go handle()
+}
+
 // inLoop is an incoming events loop
-func (c *Channel) inLoop(e *event) error {
+func (c *Channel) inLoop(e *event) (err error) {
+	// preventing malformed packet panic from crashing the process
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: decoding panic: %v", protocol.ErrorWrongPacket, r)
+			c.log().Debug("Channel.inLoop() failed to decode message", logging.Err(err))
+			e.callError(c, &EventError{Type: ErrorTypeProtocol, Err: err})
+			c.close(e, DisconnectParseError)
+		}
+	}()
+
+	var binaryMessage *protocol.Message // binary packet awaiting for it's attachments
+	conn := c.connection()
 	for {
-		message, err := c.conn.GetMessage()
+		message, err := conn.GetMessage()
 		if err != nil {
-			logging.Log().Debugf("Channel.inLoop(), c.conn.GetMessage() err: %v, message: %s", err, message)
-			return c.close(e)
+			if upgraded := c.connection(); upgraded != conn { // continue with the upgraded connection
+				c.log().Debug("Channel.inLoop() continues with the upgraded connection")
+				conn = upgraded
+				continue
+			}
+			c.log().Debug("Channel.inLoop() failed to get message", logging.Err(err))
+			return c.close(e, transportDisconnect(err))
 		}
 
-		if message == transport.StopMessage {
-			logging.Log().Debug("Channel.inLoop(): StopMessage")
-			return nil
+		var decodedMessage *protocol.Message
+		if protocol.IsBinary(message) {
+			if binaryMessage == nil {
+				c.log().Debug("Channel.inLoop() received unexpected binary message")
+				continue
+			}
+
+			binaryMessage.Attachments = append(binaryMessage.Attachments, protocol.DecodeBinary(message))
+			if len(binaryMessage.Attachments) < binaryMessage.AttachmentsCount {
+				continue
+			}
+
+			decodedMessage, binaryMessage = binaryMessage, nil
+			if decodedMessage.Args, err = protocol.Reconstruct(decodedMessage.Args, decodedMessage.Attachments); err != nil {
+				c.log().Debug("Channel.inLoop() failed to reconstruct binary message",
+					logging.Err(err), logging.F(logging.KeyMessage, decodedMessage.Source))
+				e.callError(c, &EventError{Type: ErrorTypeProtocol, Err: err})
+				c.close(e, DisconnectParseError)
+				return err
+			}
+		} else {
+			if decodedMessage, err = protocol.Decode(message); err != nil {
+				c.log().Debug("Channel.inLoop() failed to decode message", logging.Err(err), logging.F(logging.KeyMessage, message))
+				e.callError(c, &EventError{Type: ErrorTypeProtocol, Err: err})
+				c.close(e, DisconnectParseError)
+				return err
+			}
+
+			if decodedMessage.AttachmentsCount > 0 {
+				binaryMessage = decodedMessage
+				continue
+			}
 		}
 
-		decodedMessage, err := protocol.Decode(message)
-		if err != nil {
-			logging.Log().Debugf("Channel.inLoop() decoding err: %v, message: %s", err, message)
-			c.close(e)
-			return err
+		if !protocol.IsDefaultNamespace(decodedMessage.Namespace) {
+			c.processNamespaced(decodedMessage)
+			continue
 		}
 
 		switch decodedMessage.Type {
 		case protocol.MessageTypeOpen:
-			logging.Log().Debugf("Channel.inLoop(), protocol.MessageTypeOpen, decodedMessage: %+v", decodedMessage)
+			c.log().Debug("Channel.inLoop() received open message", logging.F(logging.KeyMessage, decodedMessage.Source))
 			if err := json.Unmarshal([]byte(decodedMessage.Source[1:]), &c.connHeader); err != nil {
-				c.close(e)
+				e.callError(c, &EventError{Type: ErrorTypeProtocol, Err: err})
+				c.close(e, DisconnectParseError)
+				return err
+			}
+			c.connMu.Lock()
+			c.updateLog() // the client learns it's sid
+			c.connMu.Unlock()
+			if c.eio != transport.ProtocolVersion4 { // OnConnection fires at CONNECT packet
+				e.callHandler(c, OnConnection)
 			}
-			e.callHandler(c, OnConnection)
 
 		case protocol.MessageTypePing:
-			logging.Log().Debugf("Channel.inLoop(), protocol.MessageTypePing, decodedMessage: %+v", decodedMessage)
-			if decodedMessage.Source == protocol.MessagePingProbe {
-				logging.Log().Debugf("Channel.inLoop(), decodedMessage.Source: %s", decodedMessage.Source)
-				c.outC <- protocol.MessagePongProbe
-				c.upgradedC <- transport.UpgradedMessage
+			c.log().Debug("Channel.inLoop() received ping")
+			c.outC <- []string{protocol.MessagePong}
+
+		case protocol.MessageTypeEmpty:
+			if c.server != nil {
+				c.server.connectNamespace(c, protocol.DefaultNamespace, decodedMessage.Args)
 			} else {
-				c.outC <- protocol.MessagePong
+				if c.eio == transport.ProtocolVersion4 {
+					e.callHandler(c, OnConnection)
+				}
+				c.connected(nil)
 			}
 
+		case protocol.MessageTypeError:
+			if c.server == nil {
+				c.connected(newConnectError(decodedMessage.Args))
+				return c.close(e, DisconnectServer)
+			}
+
+		case protocol.MessageTypeDisconnect:
+			c.log().Debug("Channel.inLoop() received disconnect")
+			return c.close(e, c.remoteDisconnect())
+
+		case protocol.MessageTypeClose:
+			c.log().Debug("Channel.inLoop() received close")
+			return c.close(e, DisconnectTransportClose)
+
 		case protocol.MessageTypeUpgrade:
 		case protocol.MessageTypeBlank:
 		case protocol.MessageTypePong:
 		default:
-			go e.processIncoming(c, decodedMessage)
+			c.dispatch(e, decodedMessage)
 		}
 	}
-
-	return nil
 }
 
 // outLoop is an outgoing events loop, sends messages from channel to socket
 func (c *Channel) outLoop(e *event) error {
+	defer close(c.outDoneC)
 	for {
 		outBufferLen := len(c.outC)
-		logging.Log().Debug("Channel.outLoop(), outBufferLen:", outBufferLen)
-		switch {
-		case outBufferLen >= queueBufferSize-1:
-			logging.Log().Debug("Channel.outLoop(), outBufferLen >= queueBufferSize-1")
-			return c.close(e)
-		case outBufferLen > int(queueBufferSize/2):
-			overfloodedMu.Lock()
-			overflooded[c] = struct{}{}
-			overfloodedMu.Unlock()
-		default:
-			overfloodedMu.Lock()
-			delete(overflooded, c)
-			overfloodedMu.Unlock()
+		if c.server != nil {
+			c.server.setOverflooding(c, outBufferLen > cap(c.outC)/2)
 		}
 
-		m := <-c.outC
+		m, _ := c.dequeue(true)
 
-		if m == protocol.MessageClose || m == protocol.MessageStub {
+		if m[0] == messageStop {
 			return nil
 		}
 
-		if err := c.conn.WriteMessage(m); err != nil {
-			logging.Log().Debug("Channel.outLoop(), failed to c.conn.WriteMessage() with err:", err)
-			return c.close(e)
+		messages, done := m, m[0] == protocol.MessageClose
+		if _, ok := c.connection().(transport.PayloadWriter); ok && !done {
+			messages, done = c.nextPayload(m)
+		}
+
+		if err := c.write(messages); err != nil {
+			c.log().Debug("Channel.outLoop() failed to write", logging.Err(err))
+			return c.close(e, DisconnectTransportError)
+		}
+		if done { // the close message is the last one written
+			return nil
+		}
+	}
+}
+
+// write messages to the channel connection. If the transport was upgraded while writing, the messages
+// are written again to the new connection only if none of them were written to the previous one.
+func (c *Channel) write(messages []string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	for {
+		conn := c.connection()
+		err := writeMessages(conn, messages)
+		if err == nil || conn == c.connection() {
+			return err
+		}
+		if !transport.IsNotWritten(err) { // the messages may be partly delivered, they are not repeated
+			c.log().Debug("Channel.write() failed to write to the upgraded connection", logging.Err(err))
+			return nil
+		}
+		c.log().Debug("Channel.write() writing to the upgraded connection")
+	}
+}
+
+// writeMessages to the connection conn, at once if it supports that
+func writeMessages(conn transport.Connection, messages []string) error {
+	if pw, ok := conn.(transport.PayloadWriter); ok {
+		return pw.WritePayload(messages)
+	}
+
+	for _, m := range messages {
+		if err := conn.WriteMessage(m); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+// nextPayload returns the messages m followed by the messages queued at the moment,
+// done is true if the queue ends with the close message or the outgoing loop should stop
+func (c *Channel) nextPayload(m []string) (messages []string, done bool) {
+	messages = append([]string{}, m...)
+	for {
+		m, ok := c.dequeue(false)
+		if !ok {
+			return messages, false
+		}
+		switch m[0] {
+		case messageStop:
+			return messages, true
+		case protocol.MessageClose:
+			return append(messages, m...), true
+		}
+		messages = append(messages, m...)
+	}
+}
+
 // pingLoop sends ping messages for keeping connection alive
 func (c *Channel) pingLoop() {
 	for {
-		interval, _ := c.conn.PingParams()
+		interval, _ := c.connection().PingParams()
 		time.Sleep(interval)
 		if !c.IsAlive() {
 			return
 		}
 
-		c.outC <- protocol.MessagePing
+		c.outC <- []string{protocol.MessagePing}
 	}
 }
 
 // send message packet to the given channel c with payload
 func (c *Channel) send(m *protocol.Message, payload interface{}) error {
+	return c.sendContext(context.Background(), m, payload)
+}
+
+// sendContext sends message packet to the given channel c with payload,
+// ctx limits the time the sender waits for room in the outgoing queue with the blocking queue policy
+func (c *Channel) sendContext(ctx context.Context, m *protocol.Message, payload interface{}) error {
+	if m.Namespace == "" {
+		m.Namespace = c.nsp
+	}
+
+	packets, err := encodePackets(m, payload)
+	if err != nil {
+		return err
+	}
+	if err := c.enqueue(ctx, packets); err != nil {
+		return err
+	}
+
+	if m.Type == protocol.MessageTypeEmit || m.Type == protocol.MessageTypeAckRequest {
+		c.countSent(m.EventName, packets)
+	}
+	return nil
+}
+
+// encodePackets encodes message packet m with payload, returns the packet followed by it's binary attachments
+func encodePackets(m *protocol.Message, payload interface{}) (packets []string, err error) {
 	// preventing encoding/json "index out of range" panic
 	defer func() {
 		if r := recover(); r != nil {
-			logging.Log().Warn("Channel.send(): recovered from panic:", r)
+			packets, err = nil, fmt.Errorf("encoding panic: %v", r)
 		}
 	}()
 
 	if payload != nil {
+		payload, m.Attachments = protocol.Deconstruct(payload)
 		b, err := json.Marshal(&payload)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		m.Args = string(b)
 	}
 
 	command, err := protocol.Encode(m)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if len(c.outC) == queueBufferSize {
-		return ErrorSocketOverflood
+	packets = append(make([]string, 0, len(m.Attachments)+1), command)
+	for _, attachment := range m.Attachments {
+		packets = append(packets, protocol.EncodeBinary(attachment))
 	}
+	return packets, nil
+}
 
-	c.outC <- command
-	return nil
+// encodeEvent passes the event with the given name and payload to the outbound interceptors of the channel c
+// and returns the event packet followed by it's binary attachments
+func (c *Channel) encodeEvent(name string, payload interface{}) ([]string, error) {
+	payload, err := c.events.interceptOutbound(c, name, payload)
+	if err != nil {
+		return nil, err
+	}
+	return encodePackets(&protocol.Message{Type: protocol.MessageTypeEmit, Namespace: c.nsp, EventName: name}, payload)
 }
 
 // Emit an asynchronous event with the given name and payload
 func (c *Channel) Emit(name string, payload interface{}) error {
+	return c.EmitContext(context.Background(), name, payload)
+}
+
+// EmitContext emits an asynchronous event with the given name and payload,
+// ctx limits the time it waits for room in the outgoing queue with the blocking queue policy
+func (c *Channel) EmitContext(ctx context.Context, name string, payload interface{}) error {
+	payload, err := c.events.interceptOutbound(c, name, payload)
+	if err != nil {
+		return err
+	}
+
 	message := &protocol.Message{Type: protocol.MessageTypeEmit, EventName: name}
-	return c.send(message, payload)
+	return c.sendContext(ctx, message, payload)
 }
 
 // Ack a synchronous event with the given name and payload and wait for/receive the response
 func (c *Channel) Ack(name string, payload interface{}, timeout time.Duration) (string, error) {
+	payload, err := c.events.interceptOutbound(c, name, payload)
+	if err != nil {
+		return "", err
+	}
+
 	m := &protocol.Message{Type: protocol.MessageTypeAckRequest, AckID: c.ack.nextId(), EventName: name}
 
 	ackC := make(chan string)
 	c.ack.register(m.AckID, ackC)
 
-	if err := c.send(m, payload); err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	if err := c.sendContext(ctx, m, payload); err != nil {
 		c.ack.unregister(m.AckID)
+		return "", err
 	}
 
 	select {
 	case result := <-ackC:
+		c.observeAck(name, start)
 		return result, nil
-	case <-time.After(timeout):
+	case <-ctx.Done():
 		c.ack.unregister(m.AckID)
 		return "", ErrorSendTimeout
 	}
@@ -275,70 +702,54 @@ func (c *Channel) IP() string {
 // RequestHeader returns a connection request connectionHeader
 func (c *Channel) RequestHeader() http.Header { return c.header }
 
-// Join this channel to the given room
+// Join this channel to the given room of it's namespace
 func (c *Channel) Join(room string) error {
-	if c.server == nil {
+	if c.namespace == nil {
 		return ErrorServerNotSet
 	}
 
-	c.server.channelsMu.Lock()
-	defer c.server.channelsMu.Unlock()
-
-	if _, ok := c.server.channels[room]; !ok {
-		c.server.channels[room] = make(map[*Channel]struct{})
-	}
-
-	if _, ok := c.server.rooms[c]; !ok {
-		c.server.rooms[c] = make(map[string]struct{})
-	}
-
-	c.server.channels[room][c], c.server.rooms[c][room] = struct{}{}, struct{}{}
+	c.namespace.Adapter().Join(c, room)
 	return nil
 }
 
 // Leave the given room (remove channel from it)
 func (c *Channel) Leave(room string) error {
-	if c.server == nil {
+	if c.namespace == nil {
 		return ErrorServerNotSet
 	}
 
-	c.server.channelsMu.Lock()
-	defer c.server.channelsMu.Unlock()
-
-	if _, ok := c.server.channels[room]; ok {
-		delete(c.server.channels[room], c)
-		if len(c.server.channels[room]) == 0 {
-			delete(c.server.channels, room)
-		}
-	}
+	c.namespace.Adapter().Leave(c, room)
+	return nil
+}
 
-	if _, ok := c.server.rooms[c]; ok {
-		delete(c.server.rooms[c], room)
+// Rooms returns names of the rooms the channel is joined to
+func (c *Channel) Rooms() []string {
+	if c.namespace == nil {
+		return []string{}
 	}
-
-	return nil
+	return c.namespace.Adapter().Rooms(c)
 }
 
 // Amount returns an amount of channels joined to the given room, using channel
 func (c *Channel) Amount(room string) int {
-	if c.server == nil {
+	if c.namespace == nil {
 		return 0
 	}
-	return c.server.Amount(room)
+	return c.namespace.Amount(room)
 }
 
 // List returns a list of channels joined to the given room, using channel
 func (c *Channel) List(room string) []*Channel {
-	if c.server == nil {
+	if c.namespace == nil {
 		return []*Channel{}
 	}
-	return c.server.List(room)
+	return c.namespace.List(room)
 }
 
 // BroadcastTo the the given room an event with given name and payload, using channel
 func (c *Channel) BroadcastTo(room, name string, payload interface{}) {
-	if c.server == nil {
+	if c.namespace == nil {
 		return
 	}
-	c.server.BroadcastTo(room, name, payload)
+	c.namespace.BroadcastTo(room, name, payload)
 }
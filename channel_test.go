@@ -0,0 +1,59 @@
+package gosocketio
+
+import (
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/mtfelian/golang-socketio/logging"
+	"github.com/mtfelian/golang-socketio/transport"
+)
+
+// benchmarkRoundTrip measures the ack emitted by the client and answered by the server over websocket,
+// both logging to the logger l
+func benchmarkRoundTrip(b *testing.B, l logging.Logger) {
+	s := NewServer()
+	s.SetLogger(l)
+	s.On("echo", func(c *Channel, payload benchmarkPayload) benchmarkPayload { return payload })
+
+	httpServer := httptest.NewServer(s)
+	defer httpServer.Close()
+	u, err := url.Parse(httpServer.URL)
+	if err != nil {
+		b.Fatal(err)
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	tr := transport.DefaultWebsocketTransport()
+	tr.Logger = l
+	c, err := DialWithParams(AddrWebsocket("127.0.0.1", port, false), tr, DialParams{Logger: l})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer c.Close()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.Ack("echo", broadcastPayload, time.Second); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkEmitRoundTrip compares the allocations with the logging disabled and with the debug records
+// filtered out by the logger level, the logging allocates nothing in both cases
+func BenchmarkEmitRoundTrip(b *testing.B) {
+	b.Run("nop", func(b *testing.B) { benchmarkRoundTrip(b, logging.Nop()) })
+	b.Run("info", func(b *testing.B) {
+		l := &logrus.Logger{Formatter: new(logrus.TextFormatter), Out: io.Discard, Level: logrus.InfoLevel}
+		benchmarkRoundTrip(b, logging.Logrus(l))
+	})
+}
@@ -1,10 +1,14 @@
 package gosocketio
 
 import (
+	"encoding/json"
+	"errors"
+	"net/url"
 	"strconv"
+	"time"
 
-	_ "time"
-
+	"github.com/mtfelian/golang-socketio/logging"
+	"github.com/mtfelian/golang-socketio/protocol"
 	"github.com/mtfelian/golang-socketio/transport"
 )
 
@@ -18,10 +22,16 @@ const (
 	socketioPollingURL  = "/socket.io/?EIO=3&transport=polling"
 )
 
+var (
+	ErrorClientNotConnected = errors.New("client is not connected")
+)
+
 // Client represents socket.io client
 type Client struct {
 	*event
 	*Channel
+
+	auth interface{} // auth payload of the CONNECT packets, protocol v4 only
 }
 
 // AddrWebsocket returns an url for socket.io connection for websocket transport
@@ -42,31 +52,248 @@ func AddrPolling(host string, port int, secure bool) string {
 	return prefix + host + ":" + strconv.Itoa(port) + socketioPollingURL
 }
 
+// DialParams represents the client connection parameters
+type DialParams struct {
+	// ProtocolVersion is an engine.io protocol version to speak, if not set the EIO parameter of addr is used
+	ProtocolVersion int
+	// Auth is a payload of the CONNECT packets sent to the server, protocol v4 only
+	Auth interface{}
+	// Fallback transports are tried in order if the dial transport fails to connect,
+	// the dial addr is adjusted for the websocket and polling transports
+	Fallback []transport.Transport
+	// Dispatch configures the ordered dispatch of the incoming events, see DispatchParams
+	Dispatch DispatchParams
+	// Logger of the client, nil for the default logger configured by the SIO_LL env var,
+	// the transports log to their own Logger
+	Logger logging.Logger
+}
+
+// ConnectError represents an error sent by the server to reject the namespace connection
+type ConnectError struct {
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// Error implements error interface
+func (e *ConnectError) Error() string { return "connect error: " + e.Message }
+
+// newConnectError creates ConnectError from the CONNECT_ERROR packet args,
+// protocol v3 servers send just a string message
+func newConnectError(args string) *ConnectError {
+	e := &ConnectError{}
+	if err := json.Unmarshal([]byte(args), e); err != nil {
+		if err := json.Unmarshal([]byte(args), &e.Message); err != nil {
+			e.Message = args
+		}
+	}
+	return e
+}
+
+// withProtocolVersion returns addr with the EIO query parameter set to the given protocol version
+func withProtocolVersion(addr string, version int) (string, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	query.Set("EIO", strconv.Itoa(version))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
 // Dial connects to server and initializes socket.io protocol
 // The correct ws protocol addr example:
 // ws://myserver.com/socket.io/?EIO=3&transport=websocket
 func Dial(addr string, tr transport.Transport) (*Client, error) {
-	c := &Client{Channel: &Channel{}, event: &event{}}
+	return DialWithParams(addr, tr, DialParams{})
+}
+
+// DialWithParams connects to server with the given params and initializes socket.io protocol,
+// it waits for the server to accept the default namespace connection.
+// If tr fails to connect, the fallback transports of params are tried in order.
+func DialWithParams(addr string, tr transport.Transport, params DialParams) (*Client, error) {
+	var err error
+	if params.ProtocolVersion != 0 {
+		if addr, err = withProtocolVersion(addr, params.ProtocolVersion); err != nil {
+			return nil, err
+		}
+	}
+
+	c, err := dial(addr, tr, params)
+	for _, fallback := range params.Fallback {
+		if err == nil {
+			break
+		}
+
+		logging.NewEntry(params.Logger).Debug("DialWithParams() falls back to the next transport", logging.Err(err))
+		var fallbackAddr string
+		if fallbackAddr, err = transportAddr(addr, fallback); err == nil {
+			c, err = dial(fallbackAddr, fallback, params)
+		}
+	}
+	return c, err
+}
+
+// transportAddr returns addr with the scheme and the transport query parameter set for the transport tr,
+// addr is returned as is for the unknown transports
+func transportAddr(addr string, tr transport.Transport) (string, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", err
+	}
+
+	secure := u.Scheme == "wss" || u.Scheme == "https"
+	query := u.Query()
+	switch tr.(type) {
+	case *transport.WebsocketTransport:
+		u.Scheme = "ws"
+		if secure {
+			u.Scheme = "wss"
+		}
+		query.Set("transport", "websocket")
+	case *transport.PollingClientTransport:
+		u.Scheme = "http"
+		if secure {
+			u.Scheme = "https"
+		}
+		query.Set("transport", "polling")
+	default:
+		return addr, nil
+	}
+
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// dial connects to server with the given transport tr
+func dial(addr string, tr transport.Transport, params DialParams) (*Client, error) {
+	c := &Client{Channel: &Channel{}, event: &event{}, auth: params.Auth}
 	c.Channel.init()
 	c.event.init()
+	c.Channel.events = c.event
+	c.Channel.dispatcher = newDispatcher(params.Dispatch)
 
-	var err error
-	c.conn, err = tr.Connect(addr)
+	conn, err := tr.Connect(addr)
 	if err != nil {
 		return nil, err
 	}
+	c.conn = conn
+	c.Channel.setLogger(params.Logger)
+
+	c.eio = transport.ProtocolVersion3
+	if u, err := url.Parse(addr); err == nil && u.Query().Get("EIO") == "4" {
+		c.eio = transport.ProtocolVersion4
+	}
 
 	go c.Channel.inLoop(c.event)
 	go c.Channel.outLoop(c.event)
-	go c.Channel.pingLoop()
 
-	switch tr.(type) {
-	case *transport.PollingClientTransport:
-		go c.event.callHandler(c.Channel, OnConnection)
+	polling, isPolling := c.conn.(*transport.PollingClientConnection)
+	if c.eio == transport.ProtocolVersion4 { // server sends pings, OnConnection fires at CONNECT packet
+		if err := c.connect(); err != nil {
+			c.Close()
+			return nil, err
+		}
+	} else {
+		go c.Channel.pingLoop()
+
+		if isPolling { // the connect message is received by the polling transport
+			go c.event.callHandler(c.Channel, OnConnection)
+		} else if err := c.waitConnected(); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	if isPolling {
+		go c.upgrade(polling)
 	}
 
 	return c, nil
 }
 
-// Close client connection
-func (c *Client) Close() { c.Channel.close(c.event) }
+// upgrade the client polling connection to websocket, the client keeps polling if the upgrade fails
+func (c *Client) upgrade(polling *transport.PollingClientConnection) {
+	c.Channel.setUpgrading(true)
+	defer c.Channel.setUpgrading(false)
+
+	conn, err := polling.Upgrade()
+	if err != nil {
+		c.log().Debug("Client.upgrade() failed to probe", logging.Err(err))
+		return
+	}
+
+	// pausing the outgoing loop: the polling request in-flight completes before the upgrade message
+	// and the following messages are written to the upgraded connection
+	c.Channel.writeMu.Lock()
+	defer c.Channel.writeMu.Unlock()
+
+	if err := conn.WriteMessage(protocol.MessageUpgrade); err != nil {
+		c.log().Debug("Client.upgrade() failed to write upgrade message", logging.Err(err))
+		conn.Close()
+		return
+	}
+
+	if !c.Channel.upgradeConnection(polling, conn) {
+		conn.Close()
+		return
+	}
+	polling.Discard()
+	c.log().Debug("Client.upgrade() upgraded to websocket")
+}
+
+// connect sends the CONNECT packet for the default namespace and waits for the server response
+func (c *Client) connect() error {
+	if err := c.Channel.send(&protocol.Message{Type: protocol.MessageTypeEmpty}, c.auth); err != nil {
+		return err
+	}
+
+	return c.waitConnected()
+}
+
+// waitConnected waits for the server to accept the default namespace connection
+func (c *Client) waitConnected() error {
+	_, timeout := c.connection().PingParams()
+	select {
+	case err := <-c.Channel.connectC:
+		return err
+	case <-time.After(timeout):
+		return ErrorSendTimeout
+	}
+}
+
+// Of connects to the namespace with the given name over the client connection,
+// the returned client has it's own handlers and shares the connection with c
+func (c *Client) Of(name string) (*Client, error) {
+	name = namespaceName(name)
+	if protocol.IsDefaultNamespace(name) {
+		return c, nil
+	}
+
+	root := c.Channel.root()
+	if !root.IsAlive() {
+		return nil, ErrorClientNotConnected
+	}
+
+	if nc, ok := root.namespaceChannel(name); ok {
+		return &Client{Channel: nc, event: nc.events, auth: c.auth}, nil
+	}
+
+	nsp := &Client{event: &event{}, auth: c.auth}
+	nsp.event.init()
+	nsp.Channel = root.addNamespaceChannel(name, nsp.event)
+
+	var auth interface{}
+	if root.eio == transport.ProtocolVersion4 {
+		auth = c.auth
+	}
+
+	if err := nsp.Channel.send(&protocol.Message{Type: protocol.MessageTypeEmpty}, auth); err != nil {
+		nsp.Channel.closeNamespace(nil, false, DisconnectClient)
+		return nil, err
+	}
+	return nsp, nil
+}
+
+// Close client connection, for the namespace client only disconnects from the namespace
+func (c *Client) Close() { c.Channel.close(c.event, DisconnectClient) }
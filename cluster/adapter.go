@@ -0,0 +1,128 @@
+package cluster
+
+import (
+	"github.com/mtfelian/golang-socketio"
+	"github.com/mtfelian/golang-socketio/logging"
+)
+
+// Adapter is the namespace adapter broadcasting to the channels of all the cluster nodes.
+// Broadcasts are not delivered to the peers being disconnected at the moment.
+type Adapter struct {
+	*gosocketio.MemoryAdapter
+
+	node      *Node
+	namespace *gosocketio.Namespace
+}
+
+// Broadcast the event with the given name and payload to the channels selected by opts on all the nodes
+func (a *Adapter) Broadcast(opts gosocketio.BroadcastOptions, name string, payload interface{}) {
+	a.MemoryAdapter.Broadcast(opts, name, payload)
+
+	m := &message{Type: messageBroadcast, Namespace: a.namespace.Name(), Rooms: opts.Rooms, Name: name,
+		Except: opts.Except, ExceptIDs: opts.ExceptIDs, Volatile: opts.Volatile}
+	if err := m.setPayload(payload); err != nil {
+		a.node.log().Warn("cluster.Adapter.Broadcast() failed to marshal payload",
+			logging.F(logging.KeyEvent, name), logging.Err(err))
+		return
+	}
+	a.node.send(m)
+}
+
+// Amount returns an amount of channels joined to the room on all the nodes
+func (a *Adapter) Amount(room string) int {
+	amount := a.MemoryAdapter.Amount(room)
+	for _, response := range a.request(requestAmount, []string{room}, nil) {
+		amount += response.Amount
+	}
+	return amount
+}
+
+// CountRooms returns an amount of rooms with at least one joined channel on all the nodes
+func (a *Adapter) CountRooms() int {
+	rooms := make(map[string]struct{})
+	for _, room := range a.MemoryAdapter.RoomNames() {
+		rooms[room] = struct{}{}
+	}
+
+	for _, response := range a.request(requestRooms, nil, nil) {
+		for _, room := range response.Rooms {
+			rooms[room] = struct{}{}
+		}
+	}
+	return len(rooms)
+}
+
+// ListIDs returns ids of the channels joined to the room on all the nodes,
+// List returns the local channels only since the remote ones can't be represented by *gosocketio.Channel
+func (a *Adapter) ListIDs(room string) []string {
+	ids := channelIDs(a.MemoryAdapter.List(room))
+	for _, response := range a.request(requestList, []string{room}, nil) {
+		ids = append(ids, response.IDs...)
+	}
+	return ids
+}
+
+// Disconnect the channel with the given id on any of the nodes, returns false if it's not found
+func (a *Adapter) Disconnect(id string) bool {
+	if c, err := a.namespace.GetChannel(id); err == nil {
+		c.Close()
+		return true
+	}
+
+	for _, response := range a.request(requestDisconnect, nil, []string{id}) {
+		if response.Amount > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// request the peers with the request of the given type
+func (a *Adapter) request(request string, rooms, ids []string) []*message {
+	return a.node.request(&message{Namespace: a.namespace.Name(), Request: request, Rooms: rooms, IDs: ids})
+}
+
+// broadcastLocal broadcasts the message m received from the peer to the local channels
+func (a *Adapter) broadcastLocal(m *message) {
+	payload, err := m.payload()
+	if err != nil {
+		a.node.log().Debug("cluster.Adapter.broadcastLocal() failed to rebuild payload",
+			logging.F(logging.KeyEvent, m.Name), logging.Err(err))
+		return
+	}
+	opts := gosocketio.BroadcastOptions{Rooms: m.Rooms, Except: m.Except, ExceptIDs: m.ExceptIDs,
+		Volatile: m.Volatile}
+	a.MemoryAdapter.Broadcast(opts, m.Name, payload)
+}
+
+// reply fills the response to the request m received from the peer
+func (a *Adapter) reply(m, response *message) {
+	switch m.Request {
+	case requestAmount:
+		if len(m.Rooms) == 1 {
+			response.Amount = a.MemoryAdapter.Amount(m.Rooms[0])
+		}
+	case requestRooms:
+		response.Rooms = a.MemoryAdapter.RoomNames()
+	case requestList:
+		if len(m.Rooms) == 1 {
+			response.IDs = channelIDs(a.MemoryAdapter.List(m.Rooms[0]))
+		}
+	case requestDisconnect:
+		for _, id := range m.IDs {
+			if c, err := a.namespace.GetChannel(id); err == nil {
+				c.Close()
+				response.Amount++
+			}
+		}
+	}
+}
+
+// channelIDs returns ids of the given channels
+func channelIDs(channels []*gosocketio.Channel) []string {
+	ids := make([]string, 0, len(channels))
+	for _, c := range channels {
+		ids = append(ids, c.Id())
+	}
+	return ids
+}
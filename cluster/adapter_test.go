@@ -0,0 +1,222 @@
+package cluster
+
+import (
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mtfelian/golang-socketio"
+	"github.com/mtfelian/golang-socketio/internal/adaptertest"
+	"github.com/mtfelian/golang-socketio/logging"
+)
+
+// freePorts returns the given amount of free loopback ports
+func freePorts(t *testing.T, amount int) []int {
+	var ports []int
+	for i := 0; i < amount; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		ports = append(ports, listener.Addr().(*net.TCPAddr).Port)
+	}
+	return ports
+}
+
+// peerAddresses returns loopback addresses of the nodes listening on the ports
+func peerAddresses(ports []int) []string {
+	var peers []string
+	for _, port := range ports {
+		peers = append(peers, "127.0.0.1:"+strconv.Itoa(port))
+	}
+	return peers
+}
+
+// newTestServer starts the socket.io server with the node listening on the loopback interface at port,
+// clients emitting "join" with ack are joined to the room
+func newTestServer(t *testing.T, port int, peers []string) *adaptertest.Server {
+	return newTestServerSecret(t, port, peers, "secret")
+}
+
+// newTestServerSecret starts the socket.io server like newTestServer with the given cluster secret
+func newTestServerSecret(t *testing.T, port int, peers []string, secret string) *adaptertest.Server {
+	n, err := New(Params{Address: "127.0.0.1:" + strconv.Itoa(port), Peers: peers, Secret: secret,
+		Timeout: time.Second, Logger: logging.Nop()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return adaptertest.NewServer(t, n.NewAdapter, func() { n.Close() })
+}
+
+// adapter returns the cluster adapter of the default namespace of the server s
+func adapter(s *adaptertest.Server) *Adapter { return s.Adapter().(*Adapter) }
+
+// node returns the cluster node of the server s
+func node(s *adaptertest.Server) *Node { return adapter(s).node }
+
+// waitPeers waits for every server to connect to the given amount of peers
+func waitPeers(t *testing.T, servers []*adaptertest.Server, peers int) {
+	adaptertest.WaitFor(t, func() bool {
+		for _, s := range servers {
+			if len(node(s).Peers()) != peers {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// newTestCluster starts the given amount of connected servers with the clients of adaptertest.DialClients
+func newTestCluster(t *testing.T, amount int) ([]*adaptertest.Server, []*gosocketio.Client) {
+	ports := freePorts(t, amount)
+	peers := peerAddresses(ports)
+
+	var servers []*adaptertest.Server
+	for _, port := range ports {
+		servers = append(servers, newTestServer(t, port, peers))
+	}
+	clients := adaptertest.DialClients(t, servers)
+	waitPeers(t, servers, amount-1)
+	return servers, clients
+}
+
+func TestSkipsItself(t *testing.T) {
+	ports := freePorts(t, 2)
+	peers := peerAddresses(ports)
+	aliases := []string{"localhost:" + strconv.Itoa(ports[0]), "localhost:" + strconv.Itoa(ports[1])}
+	// the nodes are listed under both addresses, so they find themselves by id only
+	servers := []*adaptertest.Server{newTestServer(t, ports[0], append(peers, aliases...)),
+		newTestServer(t, ports[1], append(aliases, peers...))}
+	waitPeers(t, servers, 2)
+
+	want := [][]string{{peers[1], aliases[1]}, {aliases[0], peers[0]}}
+	for i, s := range servers {
+		if got := node(s).Peers(); !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("server %d: got peers %v, want %v", i, got, want[i])
+		}
+	}
+}
+
+func TestWrongSecret(t *testing.T) {
+	ports := freePorts(t, 2)
+	peers := peerAddresses(ports)
+	servers := []*adaptertest.Server{newTestServer(t, ports[0], peers),
+		newTestServerSecret(t, ports[1], peers, "wrong")}
+	clients := []*gosocketio.Client{servers[0].Dial(t), servers[1].Dial(t)}
+	time.Sleep(2 * reconnectInterval) // the peers reconnect and are rejected again
+
+	for i, s := range servers {
+		if got := node(s).Peers(); len(got) != 0 {
+			t.Errorf("server %d: got peers %v, want none", i, got)
+		}
+	}
+
+	r := adaptertest.NewCounter(clients, "event")
+	servers[1].BroadcastToAll("event", nil)
+	servers[0].BroadcastToAll("event", nil)
+	r.Wait(t, []int{1, 1})
+}
+
+func TestBroadcast(t *testing.T) {
+	servers, clients := newTestCluster(t, 3)
+	adaptertest.TestBroadcast(t, servers, clients)
+}
+
+func TestBroadcastBinary(t *testing.T) {
+	servers, clients := newTestCluster(t, 2)
+	adaptertest.TestBroadcastBinary(t, servers, clients)
+}
+
+func TestAmountAndListIDs(t *testing.T) {
+	servers, clients := newTestCluster(t, 3)
+	extra := servers[1].Dial(t, "a", "c")
+
+	want := []string{clients[0].Id(), clients[2].Id(), clients[4].Id(), extra.Id()}
+	sort.Strings(want)
+
+	for i, s := range servers {
+		if got := s.Amount("a"); got != 4 {
+			t.Errorf("server %d: got amount %d, want 4", i, got)
+		}
+		if got := s.Amount("c"); got != 1 {
+			t.Errorf("server %d: got amount %d, want 1", i, got)
+		}
+		if got := s.CountRooms(); got != 3 {
+			t.Errorf("server %d: got rooms %d, want 3", i, got)
+		}
+
+		got := adapter(s).ListIDs("a")
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("server %d: got ids %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestConcurrentRequests(t *testing.T) {
+	servers, _ := newTestCluster(t, 2)
+	adaptertest.TestConcurrentRequests(t, servers)
+}
+
+func TestDisconnect(t *testing.T) {
+	servers, clients := newTestCluster(t, 2)
+
+	if !adapter(servers[0]).Disconnect(clients[3].Id()) {
+		t.Fatal("remote channel not found")
+	}
+	adaptertest.WaitFor(t, func() bool { return !clients[3].IsAlive() })
+
+	if !adapter(servers[0]).Disconnect(clients[0].Id()) {
+		t.Fatal("local channel not found")
+	}
+	adaptertest.WaitFor(t, func() bool { return !clients[0].IsAlive() })
+
+	if adapter(servers[0]).Disconnect("unknown") {
+		t.Error("unknown channel found")
+	}
+	if !clients[1].IsAlive() || !clients[2].IsAlive() {
+		t.Error("other channels disconnected")
+	}
+}
+
+func TestPeerLossAndRejoin(t *testing.T) {
+	ports := freePorts(t, 3)
+	peers := peerAddresses(ports)
+
+	var (
+		servers []*adaptertest.Server
+		clients []*gosocketio.Client
+	)
+	for _, port := range ports {
+		s := newTestServer(t, port, peers)
+		servers = append(servers, s)
+		clients = append(clients, s.Dial(t, "a"))
+	}
+	waitPeers(t, servers, 2)
+
+	servers[2].Stop()
+	waitPeers(t, servers[:2], 1)
+
+	r := adaptertest.NewCounter(clients[:2], "event")
+	servers[0].BroadcastToAll("event", nil)
+	r.Wait(t, []int{1, 1})
+	if got := servers[0].Amount("a"); got != 2 {
+		t.Errorf("got amount %d without the lost peer, want 2", got)
+	}
+
+	rejoined := newTestServer(t, ports[2], peers)
+	servers[2] = rejoined
+	clients[2] = rejoined.Dial(t, "a")
+	waitPeers(t, servers, 2)
+
+	r = adaptertest.NewCounter(clients, "rejoin")
+	servers[0].BroadcastTo("a", "rejoin", nil)
+	r.Wait(t, []int{1, 1, 1})
+	if got := servers[0].Amount("a"); got != 3 {
+		t.Errorf("got amount %d after the peer rejoined, want 3", got)
+	}
+}
@@ -0,0 +1,476 @@
+// Package cluster implements the socket.io adapter connecting several servers into a mesh over TCP,
+// so broadcasts, room queries and disconnects reach the channels of all servers without external brokers
+package cluster
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mtfelian/golang-socketio"
+	"github.com/mtfelian/golang-socketio/logging"
+	"github.com/mtfelian/golang-socketio/protocol"
+)
+
+const (
+	defaultTimeout    = 5 * time.Second
+	reconnectInterval = time.Second
+
+	messageHello     = "hello" // the first message of both sides of the connection, carries the node id and the secret
+	messageBroadcast = "broadcast"
+	messageRequest   = "request"
+	messageResponse  = "response"
+
+	requestAmount     = "amount"
+	requestRooms      = "rooms"
+	requestList       = "list"
+	requestDisconnect = "disconnect"
+)
+
+var (
+	ErrorPeerNotConnected = errors.New("peer not connected")
+	ErrorNodeClosed       = errors.New("cluster node closed")
+
+	errorSelf           = errors.New("peer is the node itself")
+	errorWrongHandshake = errors.New("wrong handshake")
+	errorWrongSecret    = errors.New("wrong secret")
+)
+
+// Params represents the cluster node parameters
+type Params struct {
+	Address string         // address to listen for the peers on, host:port
+	Peers   []string       // addresses of the nodes, the node itself is skipped
+	Secret  string         // shared by all the nodes, the peers with another secret are rejected
+	Timeout time.Duration  // timeout of writes and requests to the peers, 5 seconds if zero
+	Logger  logging.Logger // nil for the default logger
+}
+
+// message represents a message sent between the nodes, one JSON object per line
+type message struct {
+	Type      string          `json:"type"`
+	Node      string          `json:"node,omitempty"`   // node id, for the hello messages
+	Secret    string          `json:"secret,omitempty"` // cluster secret, for the hello messages
+	Namespace string          `json:"nsp"`
+	ID        string          `json:"id,omitempty"`      // request id, for the requests and the responses
+	Request   string          `json:"request,omitempty"` // request type
+	Rooms     []string        `json:"rooms,omitempty"`
+	Except    []string        `json:"except,omitempty"`
+	ExceptIDs []string        `json:"except_ids,omitempty"`
+	Volatile  bool            `json:"volatile,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	Binary    [][]byte        `json:"binary,omitempty"` // binary attachments of the payload
+	Amount    int             `json:"amount,omitempty"`
+	IDs       []string        `json:"ids,omitempty"` // channel ids, for the list and disconnect requests
+}
+
+// setPayload sets the broadcast payload of the message m, []byte values are replaced with placeholders
+// and sent aside as binary attachments
+func (m *message) setPayload(payload interface{}) error {
+	if payload == nil {
+		return nil
+	}
+
+	payload, attachments := protocol.Deconstruct(payload)
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	m.Payload, m.Binary = data, attachments
+	return nil
+}
+
+// payload returns the broadcast payload of the message m with it's binary attachments put back
+func (m *message) payload() (interface{}, error) {
+	if len(m.Payload) == 0 {
+		return nil, nil
+	}
+	return protocol.Rebuild(m.Payload, m.Binary)
+}
+
+// peer represents the outgoing connection to the other node,
+// it carries broadcasts and requests to the peer and responses back
+type peer struct {
+	address string
+
+	conn    net.Conn
+	encoder *json.Encoder
+	mu      sync.Mutex
+}
+
+// send the message m to the peer
+func (p *peer) send(m *message, timeout time.Duration) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn == nil {
+		return ErrorPeerNotConnected
+	}
+
+	p.conn.SetWriteDeadline(time.Now().Add(timeout))
+	if err := p.encoder.Encode(m); err != nil {
+		p.conn.Close()
+		return err
+	}
+	return nil
+}
+
+// setConn sets the connection to the peer, nil if the peer is lost
+func (p *peer) setConn(conn net.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.conn, p.encoder = conn, nil
+	if conn != nil {
+		p.encoder = json.NewEncoder(conn)
+	}
+}
+
+// isConnected returns true if the peer is connected
+func (p *peer) isConnected() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.conn != nil
+}
+
+// Node connects the server to the other nodes of the cluster, it's NewAdapter method should be set
+// as the server adapter. Every node listens on its address and dials all the peers, reconnecting to the lost ones.
+// The secret is sent unencrypted, the mesh should be run in the private network.
+//
+//	node, err := cluster.New(cluster.Params{Address: "10.0.0.1:7946", Peers: []string{"10.0.0.2:7946"}, Secret: secret})
+//	...
+//	server.SetAdapter(node.NewAdapter)
+type Node struct {
+	params   Params
+	id       string // unique id of the node, the peers with the same id are the node itself
+	listener net.Listener
+	peers    []*peer
+
+	adapters   map[string]*Adapter // maps namespace name to it's adapter
+	adaptersMu sync.RWMutex
+
+	requestID  int64
+	requests   map[string]chan *message // maps request id to the responses chan
+	requestsMu sync.Mutex
+
+	conns   map[net.Conn]struct{} // incoming connections of the peers
+	connsMu sync.Mutex
+
+	closeC    chan struct{}
+	closeOnce sync.Once
+}
+
+// New starts the cluster node with the given params
+func New(params Params) (*Node, error) {
+	if params.Timeout == 0 {
+		params.Timeout = defaultTimeout
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", params.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &Node{
+		params:   params,
+		id:       hex.EncodeToString(id),
+		listener: listener,
+		adapters: make(map[string]*Adapter),
+		requests: make(map[string]chan *message),
+		conns:    make(map[net.Conn]struct{}),
+		closeC:   make(chan struct{}),
+	}
+
+	for _, address := range params.Peers {
+		n.peers = append(n.peers, &peer{address: address})
+	}
+
+	go n.accept()
+	for _, p := range n.peers {
+		go n.connect(p)
+	}
+	return n, nil
+}
+
+// Close stops the node and disconnects it from the peers
+func (n *Node) Close() error {
+	n.closeOnce.Do(func() { close(n.closeC) })
+	err := n.listener.Close()
+
+	for _, p := range n.peers {
+		p.mu.Lock()
+		if p.conn != nil {
+			p.conn.Close()
+		}
+		p.mu.Unlock()
+	}
+
+	n.connsMu.Lock()
+	for conn := range n.conns {
+		conn.Close()
+	}
+	n.connsMu.Unlock()
+	return err
+}
+
+// log returns the log entry of the node
+func (n *Node) log() logging.Entry { return logging.NewEntry(n.params.Logger) }
+
+// isClosed returns true if the node is closed
+func (n *Node) isClosed() bool {
+	select {
+	case <-n.closeC:
+		return true
+	default:
+		return false
+	}
+}
+
+// Peers returns addresses of the connected peers
+func (n *Node) Peers() []string {
+	peers := make([]string, 0, len(n.peers))
+	for _, p := range n.peers {
+		if p.isConnected() {
+			peers = append(peers, p.address)
+		}
+	}
+	return peers
+}
+
+// NewAdapter creates the adapter for the namespace ns, it implements gosocketio.NewAdapterFunc
+func (n *Node) NewAdapter(ns *gosocketio.Namespace) gosocketio.Adapter {
+	a := &Adapter{
+		MemoryAdapter: gosocketio.NewMemoryAdapter(ns),
+		node:          n,
+		namespace:     ns,
+	}
+
+	n.adaptersMu.Lock()
+	n.adapters[ns.Name()] = a
+	n.adaptersMu.Unlock()
+	return a
+}
+
+// adapter returns the adapter of the namespace with the given name
+func (n *Node) adapter(name string) (*Adapter, bool) {
+	n.adaptersMu.RLock()
+	defer n.adaptersMu.RUnlock()
+	a, ok := n.adapters[name]
+	return a, ok
+}
+
+// connect to the peer p and read the responses from it, reconnecting when the connection is lost.
+// Stops if the peer turns out to be the node itself.
+func (n *Node) connect(p *peer) {
+	for !n.isClosed() {
+		conn, err := net.DialTimeout("tcp", p.address, n.params.Timeout)
+		if err == nil {
+			decoder := json.NewDecoder(conn)
+			if err = n.handshake(conn, decoder); err == errorSelf {
+				conn.Close()
+				n.log().Debug("cluster.Node.connect() skips peer being the node itself", logging.F("peer", p.address))
+				return
+			}
+
+			if err == nil {
+				n.log().Debug("cluster.Node.connect() connected to peer", logging.F("peer", p.address))
+				p.setConn(conn)
+				if n.isClosed() {
+					conn.Close()
+				}
+
+				for {
+					m := &message{}
+					if err = decoder.Decode(m); err != nil {
+						break
+					}
+					if m.Type == messageResponse {
+						n.respond(m)
+					}
+				}
+				p.setConn(nil)
+			}
+
+			conn.Close()
+			n.log().Debug("cluster.Node.connect() lost peer", logging.F("peer", p.address), logging.Err(err))
+		}
+
+		select {
+		case <-n.closeC:
+			return
+		case <-time.After(reconnectInterval):
+		}
+	}
+}
+
+// handshake sends the hello message with the node id and the secret over the connection conn to the peer
+// and reads the peer's one with decoder, returns errorWrongSecret if the peer's secret differs
+// and errorSelf if the peer is the node itself
+func (n *Node) handshake(conn net.Conn, decoder *json.Decoder) error {
+	conn.SetDeadline(time.Now().Add(n.params.Timeout))
+	defer conn.SetDeadline(time.Time{})
+
+	hello := &message{Type: messageHello, Node: n.id, Secret: n.params.Secret}
+	if err := json.NewEncoder(conn).Encode(hello); err != nil {
+		return err
+	}
+
+	m := &message{}
+	if err := decoder.Decode(m); err != nil {
+		return err
+	}
+	if m.Type != messageHello {
+		return errorWrongHandshake
+	}
+	if subtle.ConstantTimeCompare([]byte(m.Secret), []byte(n.params.Secret)) != 1 {
+		return errorWrongSecret
+	}
+	if m.Node == n.id {
+		return errorSelf
+	}
+	return nil
+}
+
+// accept the incoming connections of the peers
+func (n *Node) accept() {
+	for {
+		conn, err := n.listener.Accept()
+		if err != nil {
+			if n.isClosed() {
+				return
+			}
+			n.log().Debug("cluster.Node.accept() failed", logging.Err(err))
+			time.Sleep(reconnectInterval)
+			continue
+		}
+
+		n.connsMu.Lock()
+		n.conns[conn] = struct{}{}
+		n.connsMu.Unlock()
+		go n.serve(conn)
+	}
+}
+
+// serve the incoming connection of the peer, processing it's broadcasts and replying to it's requests
+func (n *Node) serve(conn net.Conn) {
+	defer func() {
+		n.connsMu.Lock()
+		delete(n.conns, conn)
+		n.connsMu.Unlock()
+		conn.Close()
+	}()
+
+	encoder, encoderMu := json.NewEncoder(conn), sync.Mutex{}
+	decoder := json.NewDecoder(conn)
+	if err := n.handshake(conn, decoder); err != nil { // the connection of the node to itself is closed as well
+		if err == errorWrongSecret {
+			n.log().Warn("cluster.Node.serve() rejected peer with wrong secret",
+				logging.F("peer", conn.RemoteAddr().String()))
+			return
+		}
+		n.log().Debug("cluster.Node.serve() handshake failed", logging.Err(err))
+		return
+	}
+
+	for {
+		m := &message{}
+		if err := decoder.Decode(m); err != nil {
+			return
+		}
+
+		switch m.Type {
+		case messageBroadcast:
+			if a, ok := n.adapter(m.Namespace); ok {
+				a.broadcastLocal(m)
+			}
+
+		case messageRequest:
+			go func() {
+				response := &message{Type: messageResponse, Namespace: m.Namespace, ID: m.ID}
+				if a, ok := n.adapter(m.Namespace); ok {
+					a.reply(m, response)
+				}
+
+				encoderMu.Lock()
+				defer encoderMu.Unlock()
+				conn.SetWriteDeadline(time.Now().Add(n.params.Timeout))
+				if err := encoder.Encode(response); err != nil {
+					n.log().Debug("cluster.Node.serve() failed to send response", logging.Err(err))
+				}
+			}()
+		}
+	}
+}
+
+// send the message m to all the connected peers, returns an amount of peers it was sent to
+func (n *Node) send(m *message) int {
+	sent := 0
+	for _, p := range n.peers {
+		if err := p.send(m, n.params.Timeout); err != nil {
+			if err != ErrorPeerNotConnected {
+				n.log().Debug("cluster.Node.send() failed to send to peer", logging.F("peer", p.address), logging.Err(err))
+			}
+			continue
+		}
+		sent++
+	}
+	return sent
+}
+
+// request all the connected peers with the request message m,
+// returns their responses received until the node timeout
+func (n *Node) request(m *message) []*message {
+	m.Type, m.ID = messageRequest, strconv.FormatInt(atomic.AddInt64(&n.requestID, 1), 10)
+
+	responsesC := make(chan *message, len(n.peers))
+	n.requestsMu.Lock()
+	n.requests[m.ID] = responsesC
+	n.requestsMu.Unlock()
+
+	defer func() {
+		n.requestsMu.Lock()
+		delete(n.requests, m.ID)
+		n.requestsMu.Unlock()
+	}()
+
+	sent := n.send(m)
+	responses := make([]*message, 0, sent)
+	timeout := time.After(n.params.Timeout)
+	for len(responses) < sent {
+		select {
+		case response := <-responsesC:
+			responses = append(responses, response)
+		case <-timeout:
+			n.log().Debug("cluster.Node.request() timed out", logging.F("responses", len(responses)), logging.F("peers", sent))
+			return responses
+		}
+	}
+	return responses
+}
+
+// respond passes the response m to the request waiting for it
+func (n *Node) respond(m *message) {
+	n.requestsMu.Lock()
+	responsesC, ok := n.requests[m.ID]
+	n.requestsMu.Unlock()
+	if !ok {
+		return
+	}
+
+	select {
+	case responsesC <- m:
+	default:
+	}
+}
@@ -0,0 +1,84 @@
+package gosocketio
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/mtfelian/golang-socketio/protocol"
+	"github.com/mtfelian/golang-socketio/transport"
+)
+
+// EventDisconnectReason is a name of the event sent by DisconnectWithReason with the reason message
+const EventDisconnectReason = "disconnect_reason"
+
+// DisconnectReason represents a reason the channel was disconnected for,
+// the disconnection handlers accepting it as the second argument receive the reason
+type DisconnectReason string
+
+const (
+	DisconnectTransportError DisconnectReason = "transport error"             // the connection failed
+	DisconnectTransportClose DisconnectReason = "transport close"             // the other side closed the connection
+	DisconnectPingTimeout    DisconnectReason = "ping timeout"                // nothing was received in time
+	DisconnectServer         DisconnectReason = "server namespace disconnect" // the server disconnected the channel
+	DisconnectClient         DisconnectReason = "client namespace disconnect" // the client disconnected the channel
+	DisconnectOverflood      DisconnectReason = "overflood"                   // the outgoing queue is full
+	DisconnectParseError     DisconnectReason = "parse error"                 // the other side sent a malformed packet
+	DisconnectServerShutdown DisconnectReason = "server shutting down"        // the server is shutting down
+)
+
+var disconnectReasonType = reflect.TypeOf(DisconnectReason(""))
+
+// localDisconnect returns the reason for disconnecting the channel c by this side
+func (c *Channel) localDisconnect() DisconnectReason {
+	if c.server != nil {
+		return DisconnectServer
+	}
+	return DisconnectClient
+}
+
+// remoteDisconnect returns the reason for the channel c disconnected by the other side
+func (c *Channel) remoteDisconnect() DisconnectReason {
+	if c.server != nil {
+		return DisconnectClient
+	}
+	return DisconnectServer
+}
+
+// transportDisconnect returns the reason for the channel which connection failed to get a message with err
+func transportDisconnect(err error) DisconnectReason {
+	switch {
+	case transport.IsTimeout(err):
+		return DisconnectPingTimeout
+	case transport.IsClosedByPeer(err):
+		return DisconnectTransportClose
+	}
+	return DisconnectTransportError
+}
+
+// DisconnectWithReason sends the EventDisconnectReason event with the reason message to the other side
+// and disconnects the channel from it's namespace. The root channel waits for the packets to be written
+// before closing the connection, but not longer than the connection ping timeout.
+func (c *Channel) DisconnectWithReason(reason string) error {
+	if !c.IsAlive() {
+		return ErrorClientNotConnected
+	}
+
+	_, timeout := c.root().connection().PingParams()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := c.EmitContext(ctx, EventDisconnectReason, reason)
+	if c.parent != nil {
+		c.closeNamespace(c.events, err == nil, c.localDisconnect())
+		return err
+	}
+
+	if err == nil {
+		err = c.sendContext(ctx, &protocol.Message{Type: protocol.MessageTypeDisconnect}, nil)
+	}
+	if err == nil {
+		err = flush(ctx, []*Channel{c})
+	}
+	c.close(c.events, c.localDisconnect())
+	return err
+}
@@ -0,0 +1,81 @@
+package gosocketio
+
+import (
+	"testing"
+)
+
+// disconnectionPair connects the client to the server, both collecting the reasons their channels are disconnected for
+// by the disconnection handlers accepting the reason. Returns the client, the server channel and the reason chans.
+func disconnectionPair(t *testing.T, s *Server) (*Client, *Channel, chan DisconnectReason, chan DisconnectReason) {
+	connectedC := make(chan *Channel, 1)
+	serverReasonC, clientReasonC := make(chan DisconnectReason, 1), make(chan DisconnectReason, 1)
+	s.On(OnConnection, func(c *Channel) { connectedC <- c })
+	s.On(OnDisconnection, func(c *Channel, reason DisconnectReason) { serverReasonC <- reason })
+
+	c := dialTest(t, serveTest(t, s), false)
+	c.On(OnDisconnection, func(c *Channel, reason DisconnectReason) { clientReasonC <- reason })
+	var sc *Channel
+	within(t, waitTimeout, func() { sc = <-connectedC })
+	return c, sc, serverReasonC, clientReasonC
+}
+
+// checkReason checks the reason received from reasonC of the given side is want
+func checkReason(t *testing.T, side string, reasonC chan DisconnectReason, want DisconnectReason) {
+	var reason DisconnectReason
+	within(t, waitTimeout, func() { reason = <-reasonC })
+	if reason != want {
+		t.Errorf("%s: got reason %q, want %q", side, reason, want)
+	}
+}
+
+func TestDisconnectReason(t *testing.T) {
+	t.Run("server", func(t *testing.T) {
+		_, sc, serverReasonC, clientReasonC := disconnectionPair(t, newTestServer())
+		sc.Close()
+		checkReason(t, "server", serverReasonC, DisconnectServer)
+		checkReason(t, "client", clientReasonC, DisconnectTransportClose)
+	})
+	t.Run("client", func(t *testing.T) {
+		c, _, serverReasonC, clientReasonC := disconnectionPair(t, newTestServer())
+		c.Close()
+		checkReason(t, "server", serverReasonC, DisconnectTransportClose)
+		checkReason(t, "client", clientReasonC, DisconnectClient)
+	})
+}
+
+func TestDisconnectReasonZeroArgument(t *testing.T) {
+	s := newTestServer()
+	argC := make(chan string, 1)
+	s.On(OnDisconnection, func(c *Channel, arg string) { argC <- arg })
+
+	c := dialTest(t, serveTest(t, s), false)
+	waitFor(t, func() bool { return len(s.channelsList()) == 1 })
+	c.Close()
+
+	arg := "not called"
+	within(t, waitTimeout, func() { arg = <-argC })
+	if arg != "" {
+		t.Errorf("got argument %q, want the zero value", arg)
+	}
+}
+
+func TestDisconnectWithReason(t *testing.T) {
+	c, sc, serverReasonC, clientReasonC := disconnectionPair(t, newTestServer())
+	messageC := make(chan string, 1)
+	c.On(EventDisconnectReason, func(c *Channel, message string) { messageC <- message })
+
+	if err := sc.DisconnectWithReason("banned"); err != nil {
+		t.Fatal(err)
+	}
+	var message string
+	within(t, waitTimeout, func() { message = <-messageC })
+	if message != "banned" {
+		t.Errorf("got message %q, want %q", message, "banned")
+	}
+	checkReason(t, "server", serverReasonC, DisconnectServer)
+	checkReason(t, "client", clientReasonC, DisconnectServer)
+
+	if err := sc.DisconnectWithReason("banned"); err != ErrorClientNotConnected {
+		t.Errorf("got error %v disconnecting again, want %v", err, ErrorClientNotConnected)
+	}
+}
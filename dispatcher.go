@@ -0,0 +1,109 @@
+package gosocketio
+
+import (
+	"sync"
+)
+
+const defaultDispatchQueueSize = 1000
+
+// DispatchParams configures the ordered dispatch of the incoming events: events of every channel are handled
+// sequentially in the order they were received, events of different channels are handled in parallel
+// by the pool of workers shared by all the channels. Ack responses are handled out of the order,
+// so the handlers waiting for them do not block the channel.
+type DispatchParams struct {
+	Workers   int // maximum amount of workers, if zero every event is handled in it's own goroutine
+	QueueSize int // maximum amount of events queued by all the channels, 1000 if zero
+}
+
+// dispatcher handles the incoming events in order per channel with the bounded pool of workers
+type dispatcher struct {
+	slots   chan struct{} // holds a slot for every queued event, incoming loops wait when it's full
+	readyC  chan *Channel // channels with queued events and no worker handling them
+	workers chan struct{} // holds a slot for every running worker
+}
+
+// inQueue represents the channel incoming events queued to the dispatcher
+type inQueue struct {
+	handlers  []func()
+	scheduled bool // true if the channel is in the ready queue or handled by a worker
+	mu        sync.Mutex
+}
+
+// newDispatcher creates the dispatcher with the given params, returns nil if the ordered dispatch is off
+func newDispatcher(params DispatchParams) *dispatcher {
+	if params.Workers <= 0 {
+		return nil
+	}
+	if params.QueueSize <= 0 {
+		params.QueueSize = defaultDispatchQueueSize
+	}
+
+	return &dispatcher{
+		slots:   make(chan struct{}, params.QueueSize),
+		readyC:  make(chan *Channel, params.QueueSize),
+		workers: make(chan struct{}, params.Workers),
+	}
+}
+
+// dispatch queues the handler f of the channel c event, waits if the queue is full
+func (d *dispatcher) dispatch(c *Channel, f func()) {
+	d.slots <- struct{}{}
+
+	q := &c.inQueue
+	q.mu.Lock()
+	q.handlers = append(q.handlers, f)
+	schedule := !q.scheduled
+	q.scheduled = true
+	q.mu.Unlock()
+
+	if !schedule {
+		return
+	}
+
+	d.readyC <- c // never blocks, every ready channel holds at least one slot
+	select {
+	case d.workers <- struct{}{}:
+		go d.work()
+	default: // all the workers are busy, one of them will handle the channel
+	}
+}
+
+// work handles the ready channels until there are none
+func (d *dispatcher) work() {
+	for {
+		select {
+		case c := <-d.readyC:
+			d.handle(c)
+		default:
+			<-d.workers
+			// the channel may be scheduled after the ready queue was found empty but before the slot was released
+			if len(d.readyC) == 0 {
+				return
+			}
+			select {
+			case d.workers <- struct{}{}:
+			default:
+				return
+			}
+		}
+	}
+}
+
+// handle the queued events of the channel c in order
+func (d *dispatcher) handle(c *Channel) {
+	q := &c.inQueue
+	for {
+		q.mu.Lock()
+		if len(q.handlers) == 0 {
+			q.scheduled = false
+			q.mu.Unlock()
+			return
+		}
+		f := q.handlers[0]
+		q.handlers[0], q.handlers = nil, q.handlers[1:]
+		q.mu.Unlock()
+
+		f()
+		<-d.slots
+	}
+}
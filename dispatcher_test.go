@@ -0,0 +1,140 @@
+package gosocketio
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitIdle waits for the dispatcher d to handle all the queued events and to stop all the workers
+func waitIdle(t *testing.T, d *dispatcher) {
+	waitFor(t, func() bool { return len(d.slots) == 0 && len(d.readyC) == 0 && len(d.workers) == 0 })
+}
+
+func TestDispatchOrder(t *testing.T) {
+	const (
+		workers  = 2
+		channels = 8
+		events   = 200
+	)
+	d := newDispatcher(DispatchParams{Workers: workers, QueueSize: 4})
+
+	var (
+		running, maxRunning int
+		handled             [channels][]int
+		mu                  sync.Mutex
+		inFlight            [channels]int32
+		wg                  sync.WaitGroup
+	)
+	for i := 0; i < channels; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c := &Channel{}
+			for n := 0; n < events; n++ {
+				n := n
+				d.dispatch(c, func() {
+					if atomic.AddInt32(&inFlight[i], 1) != 1 {
+						t.Errorf("channel %d: event %d handled concurrently", i, n)
+					}
+					mu.Lock()
+					if running++; running > maxRunning {
+						maxRunning = running
+					}
+					handled[i] = append(handled[i], n)
+					mu.Unlock()
+
+					time.Sleep(time.Microsecond) // the other handlers start meanwhile
+					mu.Lock()
+					running--
+					mu.Unlock()
+					atomic.AddInt32(&inFlight[i], -1)
+				})
+			}
+		}(i)
+	}
+	within(t, waitTimeout, wg.Wait)
+	waitIdle(t, d)
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i := range handled {
+		if len(handled[i]) != events {
+			t.Fatalf("channel %d: got %d events, want %d", i, len(handled[i]), events)
+		}
+		for n, got := range handled[i] {
+			if got != n {
+				t.Fatalf("channel %d: got event %d at %d", i, got, n)
+			}
+		}
+	}
+	if maxRunning > workers {
+		t.Errorf("got %d handlers running, want at most %d", maxRunning, workers)
+	}
+}
+
+func TestDispatchParallel(t *testing.T) {
+	const channels = 3
+	d := newDispatcher(DispatchParams{Workers: channels, QueueSize: 10})
+
+	var (
+		startedWG sync.WaitGroup
+		next      int32
+	)
+	releaseC := make(chan struct{})
+	startedWG.Add(channels)
+	cs := make([]*Channel, channels)
+	for i := range cs {
+		cs[i] = &Channel{}
+		d.dispatch(cs[i], func() { // handled in parallel, every handler waits for the others to start
+			startedWG.Done()
+			<-releaseC
+		})
+	}
+	d.dispatch(cs[0], func() { atomic.StoreInt32(&next, 1) })
+
+	within(t, waitTimeout, startedWG.Wait)
+	time.Sleep(50 * time.Millisecond)
+	if atomic.LoadInt32(&next) != 0 {
+		t.Error("next event of the channel handled before the previous one returned")
+	}
+
+	close(releaseC)
+	waitFor(t, func() bool { return atomic.LoadInt32(&next) == 1 })
+	waitIdle(t, d)
+}
+
+func TestDispatchBackpressure(t *testing.T) {
+	const queueSize = 2
+	d := newDispatcher(DispatchParams{Workers: 1, QueueSize: queueSize})
+
+	releaseC := make(chan struct{})
+	var handled int32
+	handle := func() {
+		<-releaseC
+		atomic.AddInt32(&handled, 1)
+	}
+	a, b := &Channel{}, &Channel{}
+	within(t, waitTimeout, func() { // the slots are not full yet
+		d.dispatch(a, handle)
+		d.dispatch(b, handle)
+	})
+
+	dispatchedC := make(chan struct{})
+	go func() {
+		d.dispatch(a, handle)
+		close(dispatchedC)
+	}()
+	select {
+	case <-dispatchedC:
+		t.Fatal("dispatched with the full queue")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	releaseC <- struct{}{} // the first handler frees the slot
+	within(t, waitTimeout, func() { <-dispatchedC })
+	close(releaseC)
+	waitFor(t, func() bool { return atomic.LoadInt32(&handled) == queueSize+1 })
+	waitIdle(t, d)
+}
@@ -1,9 +1,11 @@
 package gosocketio
 
 import (
+	"context"
 	"encoding/json"
 	"reflect"
 	"sync"
+	"time"
 
 	"github.com/mtfelian/golang-socketio/logging"
 	"github.com/mtfelian/golang-socketio/protocol"
@@ -23,6 +25,12 @@ type event struct {
 	handlers   map[string]*handler // maps handler name to handler function representation
 	handlersMu sync.RWMutex
 
+	inbound  []InboundInterceptor  // applied to incoming events before their handlers
+	outbound []OutboundInterceptor // applied to outgoing events
+
+	anyHandlers    []AnyHandler   // called for every incoming event
+	unknownHandler UnknownHandler // called for incoming events without handler
+
 	onConnection    systemEventHandler
 	onDisconnection systemEventHandler
 }
@@ -30,12 +38,19 @@ type event struct {
 // init initializes events mapping
 func (e *event) init() { e.handlers = make(map[string]*handler) }
 
-// On registers message processing function and binds it to the given event name
-func (e *event) On(name string, f interface{}) error {
+// On registers message processing function and binds it to the given event name,
+// the function may accept context.Context as the first argument to receive the channel context
+func (e *event) On(name string, f interface{}) error { return e.OnTimeout(name, 0, f) }
+
+// OnTimeout registers message processing function like On, but the context of the function is cancelled
+// after the timeout. The ack request is replied with the {"error": "handler timeout"} ack then
+// without waiting for the function to return.
+func (e *event) OnTimeout(name string, timeout time.Duration, f interface{}) error {
 	c, err := newHandler(f)
 	if err != nil {
 		return err
 	}
+	c.timeout = timeout
 
 	e.handlersMu.Lock()
 	e.handlers[name] = c
@@ -44,6 +59,55 @@ func (e *event) On(name string, f interface{}) error {
 	return nil
 }
 
+// AnyHandler is called for every incoming event with the given name and raw JSON args received on channel c
+type AnyHandler func(c *Channel, name string, args json.RawMessage)
+
+// UnknownHandler is called for the incoming event with the given name and raw JSON args received on channel c
+// if there is no handler for it. If it returns an error and the event is an ack request,
+// the error ack {"error": "<error message>"} is replied.
+type UnknownHandler func(c *Channel, name string, args json.RawMessage) error
+
+// OnAny registers the handler called for every incoming event before it's own handler
+func (e *event) OnAny(f AnyHandler) {
+	e.handlersMu.Lock()
+	e.anyHandlers = append(e.anyHandlers, f)
+	e.handlersMu.Unlock()
+}
+
+// OnUnknown sets the handler called for the incoming events without handler
+func (e *event) OnUnknown(f UnknownHandler) {
+	e.handlersMu.Lock()
+	e.unknownHandler = f
+	e.handlersMu.Unlock()
+}
+
+// callAny calls the handlers registered with OnAny for the incoming event message m on channel c
+func (e *event) callAny(c *Channel, m *protocol.Message) {
+	e.handlersMu.RLock()
+	handlers := e.anyHandlers
+	e.handlersMu.RUnlock()
+
+	for _, f := range handlers {
+		f(c, m.EventName, json.RawMessage(m.Args))
+	}
+}
+
+// callUnknown calls the handler registered with OnUnknown for the incoming event message m on channel c
+// and replies the ack request with the error ack if it returns an error
+func (e *event) callUnknown(c *Channel, m *protocol.Message) {
+	e.handlersMu.RLock()
+	f := e.unknownHandler
+	e.handlersMu.RUnlock()
+
+	if f == nil {
+		return
+	}
+
+	if err := f(c, m.EventName, json.RawMessage(m.Args)); err != nil && m.Type == protocol.MessageTypeAckRequest {
+		c.send(&protocol.Message{Type: protocol.MessageTypeAckResponse, AckID: m.AckID}, &errorAck{Error: err.Error()})
+	}
+}
+
 // findHandler returns a handler representation for the given event name
 // the second parameter is true if such event found.
 func (e *event) findHandler(name string) (*handler, bool) {
@@ -55,71 +119,133 @@ func (e *event) findHandler(name string) (*handler, bool) {
 
 // callHandler for the given channel c and event name
 func (e *event) callHandler(c *Channel, name string) {
+	defer e.recoverHandler(c, name)
+
 	if e.onConnection != nil && name == OnConnection {
-		logging.Log().Debug("event.callHandler(): OnConnection handler")
 		e.onConnection(c)
 	}
 
-	if e.onDisconnection != nil && name == OnDisconnection {
+	f, ok := e.findHandler(name)
+	if !ok {
+		c.log().Debug("event.callHandler() handler not found", logging.F(logging.KeyEvent, name))
+		return
+	}
+
+	f.call(c, &struct{}{})
+}
+
+// callDisconnection calls the disconnection handler for the given channel c,
+// the handler accepting DisconnectReason receives the reason
+func (e *event) callDisconnection(c *Channel, reason DisconnectReason) {
+	defer e.recoverHandler(c, OnDisconnection)
+
+	if e.onDisconnection != nil {
 		e.onDisconnection(c)
 	}
 
-	f, ok := e.findHandler(name)
+	f, ok := e.findHandler(OnDisconnection)
 	if !ok {
-		logging.Log().Debug("event.callHandler(): handler not found")
+		c.log().Debug("event.callDisconnection() handler not found")
 		return
 	}
 
-	f.call(c, &struct{}{})
+	switch {
+	case !f.hasArgs:
+		f.call(c, &struct{}{})
+	case f.args == disconnectReasonType:
+		f.call(c, &reason)
+	default: // the handler receives the zero value of it's argument
+		f.call(c, nil)
+	}
+}
+
+// callIncoming calls the handler f of the incoming event with the given name on channel c with arguments.
+// The handler with the timeout is called in a separate goroutine and abandoned when it's context is done,
+// the second result is false then. The result is nil if the handler panicked.
+func (e *event) callIncoming(c *Channel, f *handler, name string, arguments interface{}) ([]reflect.Value, bool) {
+	defer c.observeHandler(name, time.Now())
+
+	if f.timeout <= 0 {
+		return f.call(c, arguments), true
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), f.timeout)
+	defer cancel()
+
+	resultC := make(chan []reflect.Value, 1)
+	go func() {
+		defer close(resultC)
+		defer e.recoverHandler(c, name)
+		resultC <- f.callContext(ctx, c, arguments)
+	}()
+
+	select {
+	case result := <-resultC:
+		return result, true
+	case <-ctx.Done():
+		c.log().Debug("event.callIncoming() handler context is done", logging.F(logging.KeyEvent, name))
+		return nil, false
+	}
 }
 
 // processIncoming checks incoming message m on channel c
 func (e *event) processIncoming(c *Channel, m *protocol.Message) {
-	logging.Log().Debug("event.processIncoming() fired with:", m)
+	if log := c.log(); log.Enabled(logging.LevelDebug) {
+		log.Debug("event.processIncoming() fired", logging.F(logging.KeyEvent, m.EventName), logging.F(logging.KeyMessage, m.Source))
+	}
+	defer e.recoverHandler(c, m.EventName)
+
+	if m.Type == protocol.MessageTypeEmit || m.Type == protocol.MessageTypeAckRequest {
+		if !e.interceptInbound(c, m) {
+			c.log().Debug("event.processIncoming() event dropped by interceptor", logging.F(logging.KeyEvent, m.EventName))
+			return
+		}
+		e.callAny(c, m)
+	}
+
 	switch m.Type {
 	case protocol.MessageTypeEmit:
-		logging.Log().Debug("event.processIncoming() is finding handler for msg.Event:", m.EventName)
 		f, ok := e.findHandler(m.EventName)
 		if !ok {
-			logging.Log().Debug("event.processIncoming(): handler not found")
+			c.log().Debug("event.processIncoming() handler not found", logging.F(logging.KeyEvent, m.EventName))
+			e.callUnknown(c, m)
 			return
 		}
 
-		logging.Log().Debug("event.processIncoming() found handler:", f)
-
 		if !f.hasArgs {
-			f.call(c, &struct{}{})
+			e.callIncoming(c, f, m.EventName, &struct{}{})
 			return
 		}
 
 		data := f.arguments()
-		logging.Log().Debug("event.processIncoming(), f.arguments() returned:", data)
-
 		if err := json.Unmarshal([]byte(m.Args), &data); err != nil {
-			logging.Log().Infof("event.processIncoming() failed to json.Unmaeshal(). msg.Args: %s, data: %v, err: %v",
-				m.Args, data, err)
+			c.log().Debug("event.processIncoming() failed to unmarshal args",
+				logging.F(logging.KeyEvent, m.EventName), logging.Err(err))
+			e.callError(c, &EventError{Type: ErrorTypeDecode, Event: m.EventName, Err: err})
 			return
 		}
 
-		f.call(c, data)
+		e.callIncoming(c, f, m.EventName, data)
 
 	case protocol.MessageTypeAckRequest:
-		logging.Log().Debug("event.processIncoming() ack request")
 		f, ok := e.findHandler(m.EventName)
-		if !ok || !f.out {
+		if !ok {
+			c.log().Debug("event.processIncoming() ack handler not found", logging.F(logging.KeyEvent, m.EventName))
+			e.callUnknown(c, m)
+			return
+		}
+		if !f.out {
 			return
 		}
 
-		var result []reflect.Value
+		var data interface{} = &struct{}{}
 		if f.hasArgs {
 			// data type should be defined for Unmarshal()
-			data := f.arguments()
+			data = f.arguments()
 			if err := json.Unmarshal([]byte(m.Args), &data); err != nil {
+				e.callError(c, &EventError{Type: ErrorTypeDecode, Event: m.EventName, Err: err})
 				return
 			}
-			result = f.call(c, data)
-		} else {
-			result = f.call(c, &struct{}{})
 		}
 
 		ackResponse := &protocol.Message{
@@ -127,10 +253,18 @@ func (e *event) processIncoming(c *Channel, m *protocol.Message) {
 			AckID: m.AckID,
 		}
 
+		result, done := e.callIncoming(c, f, m.EventName, data)
+		if !done {
+			c.send(ackResponse, &errorAck{Error: ErrorHandlerTimeout.Error()})
+			return
+		}
+		if result == nil { // the handler panicked
+			return
+		}
+
 		c.send(ackResponse, result[0].Interface())
 
 	case protocol.MessageTypeAckResponse:
-		logging.Log().Debug("event.processIncoming() ack response")
 		ackC, err := c.ack.obtain(m.AckID)
 		if err == nil {
 			ackC <- m.Args
@@ -0,0 +1,76 @@
+package gosocketio
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestOnTimeout(t *testing.T) {
+	s := newTestServer()
+	errC, releaseC := make(chan error, 2), make(chan struct{})
+	t.Cleanup(func() { close(releaseC) })
+	s.OnTimeout("slow", 50*time.Millisecond, func(ctx context.Context, c *Channel, i int) int {
+		<-ctx.Done()
+		errC <- ctx.Err()
+		<-releaseC // the ack is replied without waiting for the handler to return
+		return i
+	})
+	s.OnTimeout("slowEmit", 50*time.Millisecond, func(ctx context.Context, c *Channel, i int) {
+		<-ctx.Done()
+		errC <- ctx.Err()
+	})
+	s.OnTimeout("fast", waitTimeout, func(c *Channel, i int) int { return i })
+
+	c := dialTest(t, serveTest(t, s), false)
+	if got, err := c.Ack("slow", 1, waitTimeout); err != nil || got != `{"error":"handler timeout"}` {
+		t.Errorf("got ack %q, error %v, want the handler timeout error", got, err)
+	}
+	var err error
+	within(t, waitTimeout, func() { err = <-errC })
+	if err != context.DeadlineExceeded {
+		t.Errorf("got context error %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	if err := c.Emit("slowEmit", 1); err != nil {
+		t.Fatal(err)
+	}
+	within(t, waitTimeout, func() { err = <-errC })
+	if err != context.DeadlineExceeded {
+		t.Errorf("got context error %v of the event handler, want %v", err, context.DeadlineExceeded)
+	}
+
+	if got, err := c.Ack("fast", 2, waitTimeout); err != nil || got != "2" {
+		t.Errorf("got ack %q, error %v, want 2", got, err)
+	}
+}
+
+func TestHandlerContextCancelledOnDisconnect(t *testing.T) {
+	s := newTestServer()
+	startedC, errC := make(chan *Channel, 1), make(chan error, 1)
+	s.On("wait", func(ctx context.Context, c *Channel) {
+		startedC <- c
+		<-ctx.Done()
+		errC <- ctx.Err()
+	})
+
+	c := dialTest(t, serveTest(t, s), false)
+	if err := c.Emit("wait", nil); err != nil {
+		t.Fatal(err)
+	}
+	var sc *Channel
+	within(t, waitTimeout, func() { sc = <-startedC })
+	if err := sc.Context().Err(); err != nil {
+		t.Fatalf("got context error %v of the connected channel", err)
+	}
+
+	c.Close()
+	var err error
+	within(t, waitTimeout, func() { err = <-errC })
+	if err != context.Canceled {
+		t.Errorf("got context error %v, want %v", err, context.Canceled)
+	}
+	if err := sc.Context().Err(); err != context.Canceled {
+		t.Errorf("got channel context error %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,79 @@
+package gosocketio
+
+import (
+	"fmt"
+	"reflect"
+	"runtime/debug"
+
+	"github.com/mtfelian/golang-socketio/logging"
+)
+
+// EventErrorType represents a kind of failure processing the incoming packet
+type EventErrorType string
+
+const (
+	ErrorTypeProtocol EventErrorType = "protocol error" // the packet is malformed, the channel is closed after it
+	ErrorTypeDecode   EventErrorType = "decode error"   // the event args do not fit the handler argument
+	ErrorTypeHandler  EventErrorType = "handler panic"  // the handler panicked, the panic is recovered
+)
+
+// EventError represents a failure processing the incoming packet, it's passed to the OnError handlers
+// accepting *EventError as the second argument
+type EventError struct {
+	Type  EventErrorType
+	Event string // event name, empty for the protocol errors
+	Err   error
+	Stack []byte // stack trace of the panicked handler
+}
+
+// Error implements error interface
+func (e *EventError) Error() string {
+	if e.Event == "" {
+		return string(e.Type) + ": " + e.Err.Error()
+	}
+	return string(e.Type) + " at event " + e.Event + ": " + e.Err.Error()
+}
+
+var eventErrorType = reflect.TypeOf(&EventError{})
+
+// callError calls the OnError handler for the given channel c,
+// the handler accepting *EventError receives the error err
+func (e *event) callError(c *Channel, err *EventError) {
+	c.log().Debug("event.callError() fired", logging.F(logging.KeyEvent, err.Event), logging.Err(err))
+
+	f, ok := e.findHandler(OnError)
+	if !ok {
+		return
+	}
+
+	// preventing OnError handler panic from crashing the process, it's called from the recovering code
+	defer func() {
+		if r := recover(); r != nil {
+			c.log().Error("event.callError() recovered OnError handler panic", logging.F("panic", r))
+		}
+	}()
+
+	switch {
+	case !f.hasArgs:
+		f.call(c, &struct{}{})
+	case f.args == eventErrorType:
+		f.call(c, &err)
+	default: // the handler receives the zero value of it's argument
+		f.call(c, nil)
+	}
+}
+
+// recoverHandler recovers the panic of the handler of the event with the given name on channel c
+// and passes it to the OnError handler, should be deferred
+func (e *event) recoverHandler(c *Channel, name string) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	err, ok := r.(error)
+	if !ok {
+		err = fmt.Errorf("%v", r)
+	}
+	e.callError(c, &EventError{Type: ErrorTypeHandler, Event: name, Err: err, Stack: debug.Stack()})
+}
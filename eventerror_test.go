@@ -0,0 +1,66 @@
+package gosocketio
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mtfelian/golang-socketio/logging"
+)
+
+// scriptedConnection returns the messages in order, then fails
+type scriptedConnection struct {
+	nopConnection
+	messages []string
+}
+
+func (c *scriptedConnection) GetMessage() (string, error) {
+	if len(c.messages) == 0 {
+		return "", ErrorClientNotConnected
+	}
+	m := c.messages[0]
+	c.messages = c.messages[1:]
+	return m, nil
+}
+
+func TestPanickingErrorHandler(t *testing.T) {
+	s, channels := newTestChannels(1, QueueParams{})
+	c := channels[0]
+
+	var calls int
+	s.On(OnError, func(c *Channel, err *EventError) {
+		calls++
+		panic("error handler")
+	})
+
+	s.event.callError(c, &EventError{Type: ErrorTypeProtocol, Err: errors.New("wrong packet")})
+	func() {
+		defer s.event.recoverHandler(c, "event")
+		panic("event handler")
+	}()
+	if calls != 2 {
+		t.Errorf("got %d OnError calls, want 2", calls)
+	}
+}
+
+func TestWrongOpenPacket(t *testing.T) {
+	c := &Channel{}
+	c.init()
+	e := &event{}
+	e.init()
+	c.events, c.conn = e, &scriptedConnection{messages: []string{"0{", "2"}}
+	c.setLogger(logging.Nop())
+
+	var connected, failed bool
+	e.On(OnConnection, func(c *Channel) { connected = true })
+	e.On(OnError, func(c *Channel, err *EventError) { failed = err.Type == ErrorTypeProtocol })
+
+	within(t, time.Second, func() {
+		if err := c.inLoop(e); err == nil {
+			t.Error("expected error")
+		}
+	})
+	if connected || !failed || c.IsAlive() {
+		t.Errorf("got connected %v, failed %v, alive %v, want false, true, false", connected, failed, c.IsAlive())
+	}
+}
@@ -1,24 +1,31 @@
 package gosocketio
 
 import (
+	"context"
 	"errors"
 	"reflect"
+	"time"
 )
 
 // handler is an event handler representation
 type handler struct {
-	function reflect.Value
-	args     reflect.Type
-	hasArgs  bool
-	out      bool
+	function   reflect.Value
+	args       reflect.Type
+	hasArgs    bool
+	hasContext bool // true if the first argument is context.Context
+	out        bool
+	timeout    time.Duration // the handler context is cancelled after it, zero for no timeout
 }
 
 var (
 	ErrorHandlerIsNotFunc   = errors.New("f is not a function")
-	ErrorHandlerHasNot2Args = errors.New("f should have 1 or 2 arguments")
+	ErrorHandlerHasNot2Args = errors.New("f should have 1 or 2 arguments besides the leading context")
 	ErrorHandlerWrongResult = errors.New("f should return no more than one value")
+	ErrorHandlerTimeout     = errors.New("handler timeout")
 )
 
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
 // newHandler parses function f (event handler) using reflection, and stores it's representation
 func newHandler(f interface{}) (*handler, error) {
 	fVal := reflect.ValueOf(f)
@@ -36,12 +43,18 @@ func newHandler(f interface{}) (*handler, error) {
 		out:      fType.NumOut() == 1,
 	}
 
-	switch fType.NumIn() {
+	numIn, first := fType.NumIn(), 0
+	if numIn > 0 && fType.In(0) == contextType {
+		curCaller.hasContext = true
+		numIn, first = numIn-1, 1
+	}
+
+	switch numIn {
 	case 1:
 		curCaller.args = nil
 		curCaller.hasArgs = false
 	case 2:
-		curCaller.args = fType.In(1)
+		curCaller.args = fType.In(first + 1)
 		curCaller.hasArgs = true
 	default:
 		return nil, ErrorHandlerHasNot2Args
@@ -53,8 +66,14 @@ func newHandler(f interface{}) (*handler, error) {
 // arguments returns function parameter as it is present in it using reflection
 func (h *handler) arguments() interface{} { return reflect.New(h.args).Interface() }
 
-// call func with given arguments from its representation using reflection
+// call func with given arguments from its representation using reflection,
+// the handler accepting context receives the channel context
 func (h *handler) call(c *Channel, arguments interface{}) []reflect.Value {
+	return h.callContext(c.Context(), c, arguments)
+}
+
+// callContext calls func with the given context ctx and arguments from its representation using reflection
+func (h *handler) callContext(ctx context.Context, c *Channel, arguments interface{}) []reflect.Value {
 	// nil is untyped, so use the default empty value of correct type
 	if arguments == nil {
 		arguments = h.arguments()
@@ -64,6 +83,9 @@ func (h *handler) call(c *Channel, arguments interface{}) []reflect.Value {
 	if !h.hasArgs {
 		a = a[0:1]
 	}
+	if h.hasContext {
+		a = append([]reflect.Value{reflect.ValueOf(ctx)}, a...)
+	}
 
 	return h.function.Call(a)
 }
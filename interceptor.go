@@ -0,0 +1,85 @@
+package gosocketio
+
+import (
+	"errors"
+
+	"github.com/mtfelian/golang-socketio/protocol"
+)
+
+var (
+	ErrorEventDropped = errors.New("event dropped")
+)
+
+// InboundInterceptor intercepts the incoming event with the given name and raw JSON args received on channel c,
+// returns the args to pass to the handler, possibly rewritten.
+// If it returns an error the event is dropped, and unless the error is ErrorEventDropped
+// the ack request is replied with the error ack {"error": "<error message>"}.
+type InboundInterceptor func(c *Channel, name, args string) (string, error)
+
+// OutboundInterceptor intercepts the outgoing event with the given name and payload sent to channel c
+// by Emit, Ack or broadcasts, returns the payload to send, possibly rewritten.
+// If it returns an error the event is dropped and the error is returned to the sender.
+type OutboundInterceptor func(c *Channel, name string, payload interface{}) (interface{}, error)
+
+// errorAck represents the ack response sent when the inbound interceptor rejects the ack request
+type errorAck struct {
+	Error string `json:"error"`
+}
+
+// Intercept adds the inbound interceptor to the chain applied to incoming events before their handlers
+func (e *event) Intercept(f InboundInterceptor) {
+	e.handlersMu.Lock()
+	e.inbound = append(e.inbound, f)
+	e.handlersMu.Unlock()
+}
+
+// InterceptOutbound adds the outbound interceptor to the chain applied to outgoing events
+func (e *event) InterceptOutbound(f OutboundInterceptor) {
+	e.handlersMu.Lock()
+	e.outbound = append(e.outbound, f)
+	e.handlersMu.Unlock()
+}
+
+// hasOutbound returns true if there are outbound interceptors
+func (e *event) hasOutbound() bool {
+	e.handlersMu.RLock()
+	defer e.handlersMu.RUnlock()
+	return len(e.outbound) > 0
+}
+
+// interceptInbound applies the inbound interceptors chain to the incoming event message m on channel c,
+// replies the ack request with the error ack if it's rejected. Returns false if the event is dropped.
+func (e *event) interceptInbound(c *Channel, m *protocol.Message) bool {
+	e.handlersMu.RLock()
+	inbound := e.inbound
+	e.handlersMu.RUnlock()
+
+	for _, f := range inbound {
+		args, err := f(c, m.EventName, m.Args)
+		if err != nil {
+			if m.Type == protocol.MessageTypeAckRequest && err != ErrorEventDropped {
+				c.send(&protocol.Message{Type: protocol.MessageTypeAckResponse, AckID: m.AckID},
+					&errorAck{Error: err.Error()})
+			}
+			return false
+		}
+		m.Args = args
+	}
+	return true
+}
+
+// interceptOutbound applies the outbound interceptors chain to the outgoing event with the given name and payload
+// sent to channel c, returns the payload to send
+func (e *event) interceptOutbound(c *Channel, name string, payload interface{}) (interface{}, error) {
+	e.handlersMu.RLock()
+	outbound := e.outbound
+	e.handlersMu.RUnlock()
+
+	for _, f := range outbound {
+		var err error
+		if payload, err = f(c, name, payload); err != nil {
+			return nil, err
+		}
+	}
+	return payload, nil
+}
@@ -0,0 +1,212 @@
+// Package adaptertest provides the socket.io server, client and event counter fixture shared by the tests
+// of the adapters, and the tests every adapter connecting several servers should pass
+package adaptertest
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mtfelian/golang-socketio"
+	"github.com/mtfelian/golang-socketio/logging"
+	"github.com/mtfelian/golang-socketio/transport"
+)
+
+const WaitTimeout = 5 * time.Second
+
+// Server is a socket.io server listening on the loopback interface
+type Server struct {
+	*gosocketio.Server
+	Port int
+
+	stop func()
+}
+
+// NewServer starts the socket.io server with the adapter created by newAdapter, closeAdapter is called
+// when the server stops. Clients emitting "join" with ack are joined to the room.
+func NewServer(t testing.TB, newAdapter gosocketio.NewAdapterFunc, closeAdapter func()) *Server {
+	s := gosocketio.NewServer()
+	s.SetLogger(logging.Nop())
+	s.SetAdapter(newAdapter)
+	s.On("join", func(c *gosocketio.Channel, room string) string {
+		c.Join(room)
+		return room
+	})
+
+	httpServer := httptest.NewServer(s)
+	var stopOnce sync.Once
+	stop := func() {
+		stopOnce.Do(func() {
+			httpServer.CloseClientConnections()
+			httpServer.Close()
+			closeAdapter()
+		})
+	}
+	t.Cleanup(stop)
+
+	u, err := url.Parse(httpServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Server{Server: s, Port: port, stop: stop}
+}
+
+// Stop the server closing it's clients and the adapter
+func (s *Server) Stop() { s.stop() }
+
+// Dial connects the client to the server and joins it to the rooms
+func (s *Server) Dial(t testing.TB, rooms ...string) *gosocketio.Client {
+	c, err := gosocketio.DialWithParams(gosocketio.AddrWebsocket("127.0.0.1", s.Port, false),
+		transport.DefaultWebsocketTransport(), gosocketio.DialParams{Logger: logging.Nop()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+
+	for _, room := range rooms {
+		if _, err := c.Ack("join", room, WaitTimeout); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c
+}
+
+// DialClients connects two clients to every server: the first is joined to the room "a", the second to the room "b"
+func DialClients(t testing.TB, servers []*Server) []*gosocketio.Client {
+	var clients []*gosocketio.Client
+	for _, s := range servers {
+		clients = append(clients, s.Dial(t, "a"), s.Dial(t, "b"))
+	}
+	return clients
+}
+
+// WaitFor the condition f to be true
+func WaitFor(t testing.TB, f func() bool) {
+	deadline := time.Now().Add(WaitTimeout)
+	for !f() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Counter counts the events received by the clients
+type Counter struct {
+	clients []*gosocketio.Client
+	counts  map[*gosocketio.Client]int
+	mu      sync.Mutex
+}
+
+// NewCounter counts the events with the given names received by the clients
+func NewCounter(clients []*gosocketio.Client, names ...string) *Counter {
+	r := &Counter{clients: clients, counts: make(map[*gosocketio.Client]int)}
+	for _, c := range clients {
+		c := c
+		for _, name := range names {
+			c.On(name, func(*gosocketio.Channel) {
+				r.mu.Lock()
+				r.counts[c]++
+				r.mu.Unlock()
+			})
+		}
+	}
+	return r
+}
+
+// Wait until the clients receive the events want times and no more events arrive for a while
+func (r *Counter) Wait(t testing.TB, want []int) {
+	get := func() []int {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		got := make([]int, len(r.clients))
+		for i, c := range r.clients {
+			got[i] = r.counts[c]
+		}
+		return got
+	}
+
+	deadline := time.Now().Add(WaitTimeout)
+	for !reflect.DeepEqual(get(), want) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond) // duplicates arrive
+	if got := get(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got events %v, want %v", got, want)
+	}
+}
+
+// TestBroadcast tests the broadcasts to all the channels, to the rooms and except the rooms reach the channels
+// of all the servers once. Requires three servers with the clients of DialClients.
+func TestBroadcast(t *testing.T, servers []*Server, clients []*gosocketio.Client) {
+	r := NewCounter(clients, "event")
+
+	servers[0].BroadcastToAll("event", nil)
+	r.Wait(t, []int{1, 1, 1, 1, 1, 1})
+
+	servers[1].BroadcastTo("a", "event", nil)
+	r.Wait(t, []int{2, 1, 2, 1, 2, 1})
+
+	servers[2].Except("a").Emit("event", nil)
+	r.Wait(t, []int{2, 2, 2, 2, 2, 2})
+
+	servers[0].To("a", "b").Except("b").Emit("event", nil)
+	r.Wait(t, []int{3, 2, 3, 2, 3, 2})
+}
+
+// TestBroadcastBinary tests the broadcast payload with binary data reaches the channels of all the servers intact
+func TestBroadcastBinary(t *testing.T, servers []*Server, clients []*gosocketio.Client) {
+	type file struct {
+		Name string `json:"name"`
+		Data []byte `json:"data"`
+	}
+	received := make(chan file, len(clients))
+	for _, c := range clients {
+		c.On("file", func(c *gosocketio.Channel, f file) { received <- f })
+	}
+
+	want := file{Name: "a", Data: []byte{0, 1, 0xff}}
+	servers[0].BroadcastToAll("file", want)
+	for range clients {
+		select {
+		case got := <-received:
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		case <-time.After(WaitTimeout):
+			t.Fatal("timed out")
+		}
+	}
+}
+
+// TestConcurrentRequests tests the concurrent room counts of the first server get the responses
+// of the second one matched to their requests. Requires two servers.
+func TestConcurrentRequests(t *testing.T, servers []*Server) {
+	for i := 1; i <= 5; i++ { // room "rN" has N channels on the second server
+		for j := 0; j < i; j++ {
+			servers[1].Dial(t, "r"+strconv.Itoa(i))
+		}
+	}
+
+	var wg sync.WaitGroup
+	for n := 0; n < 10; n++ {
+		for i := 1; i <= 5; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if got := servers[0].Amount("r" + strconv.Itoa(i)); got != i {
+					t.Errorf("room r%d: got amount %d, want %d", i, got, i)
+				}
+			}(i)
+		}
+	}
+	wg.Wait()
+}
@@ -0,0 +1,109 @@
+package logging
+
+// Level represents a severity of the log record
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// Keys of the structured fields the library logs with
+const (
+	KeySid       = "sid"
+	KeyTransport = "transport"
+	KeyEvent     = "event"
+	KeyMessage   = "message"
+	KeyError     = "error"
+)
+
+// Field represents a structured field of the log record
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F returns the field with the given key and value
+func F(key string, value interface{}) Field { return Field{Key: key, Value: value} }
+
+// Err returns the error field
+func Err(err error) Field { return Field{Key: KeyError, Value: err} }
+
+// Logger is a structured logger the library writes to
+type Logger interface {
+	// Enabled returns true if the records of the given level are written,
+	// the records of the disabled levels are not built at all
+	Enabled(level Level) bool
+	// Log writes the record of the given level with the message msg and the structured fields
+	Log(level Level, msg string, fields ...Field)
+}
+
+// nop is a logger discarding all the records
+type nop struct{}
+
+func (nop) Enabled(Level) bool          { return false }
+func (nop) Log(Level, string, ...Field) {}
+
+// Nop returns the logger discarding all the records
+func Nop() Logger { return nop{} }
+
+// Default returns the logger writing to the package logger Log(), the level is set by the SIO_LL env var
+func Default() Logger { return Logrus(log) }
+
+// OrDefault returns l, or the default logger if l is nil
+func OrDefault(l Logger) Logger {
+	if l == nil {
+		return Default()
+	}
+	return l
+}
+
+// Entry writes the records to the logger with the fixed fields appended to the fields of every record.
+// The level is checked before the record is built, so the records without own fields of the disabled levels
+// cost nothing, the hot paths should check Enabled() before building the fields.
+type Entry struct {
+	logger Logger
+	fields []Field
+}
+
+// NewEntry returns the entry writing to the logger l with the given fixed fields, the default logger if l is nil
+func NewEntry(l Logger, fields ...Field) Entry { return Entry{logger: OrDefault(l), fields: fields} }
+
+// With returns the entry with the given fields added to the fixed fields of e
+func (e Entry) With(fields ...Field) Entry {
+	all := make([]Field, 0, len(e.fields)+len(fields))
+	return Entry{logger: e.logger, fields: append(append(all, e.fields...), fields...)}
+}
+
+// Logger returns the logger of the entry
+func (e Entry) Logger() Logger { return e.logger }
+
+// Enabled returns true if the records of the given level are written
+func (e Entry) Enabled(level Level) bool { return e.logger != nil && e.logger.Enabled(level) }
+
+// Log writes the record of the given level with the message msg, the fields and the fixed fields of e
+func (e Entry) Log(level Level, msg string, fields ...Field) {
+	if !e.Enabled(level) {
+		return
+	}
+	if len(fields) > 0 {
+		fields = append(fields[:len(fields):len(fields)], e.fields...)
+	} else {
+		fields = e.fields
+	}
+	e.logger.Log(level, msg, fields...)
+}
+
+// Debug writes the debug record
+func (e Entry) Debug(msg string, fields ...Field) { e.Log(LevelDebug, msg, fields...) }
+
+// Info writes the info record
+func (e Entry) Info(msg string, fields ...Field) { e.Log(LevelInfo, msg, fields...) }
+
+// Warn writes the warning record
+func (e Entry) Warn(msg string, fields ...Field) { e.Log(LevelWarn, msg, fields...) }
+
+// Error writes the error record
+func (e Entry) Error(msg string, fields ...Field) { e.Log(LevelError, msg, fields...) }
@@ -0,0 +1,56 @@
+package logging
+
+import (
+	"sync/atomic"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// logrusLogger adapts the logrus logger to Logger
+type logrusLogger struct {
+	l *logrus.Logger
+}
+
+// Logrus returns the logger writing to the logrus logger l
+func Logrus(l *logrus.Logger) Logger { return logrusLogger{l: l} }
+
+// logrusLevel returns the logrus level for the given level
+func logrusLevel(level Level) logrus.Level {
+	switch level {
+	case LevelDebug:
+		return logrus.DebugLevel
+	case LevelInfo:
+		return logrus.InfoLevel
+	case LevelWarn:
+		return logrus.WarnLevel
+	}
+	return logrus.ErrorLevel
+}
+
+// Enabled implements Logger
+func (l logrusLogger) Enabled(level Level) bool {
+	return logrus.Level(atomic.LoadUint32((*uint32)(&l.l.Level))) >= logrusLevel(level)
+}
+
+// Log implements Logger
+func (l logrusLogger) Log(level Level, msg string, fields ...Field) {
+	entry := logrus.NewEntry(l.l)
+	if len(fields) > 0 {
+		data := make(logrus.Fields, len(fields))
+		for _, f := range fields {
+			data[f.Key] = f.Value
+		}
+		entry = entry.WithFields(data)
+	}
+
+	switch level {
+	case LevelDebug:
+		entry.Debug(msg)
+	case LevelInfo:
+		entry.Info(msg)
+	case LevelWarn:
+		entry.Warn(msg)
+	default:
+		entry.Error(msg)
+	}
+}
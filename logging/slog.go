@@ -0,0 +1,41 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+)
+
+// slogLogger adapts the standard structured logger to Logger
+type slogLogger struct {
+	l *slog.Logger
+}
+
+// Slog returns the logger writing to the standard structured logger l
+func Slog(l *slog.Logger) Logger { return slogLogger{l: l} }
+
+// slogLevel returns the slog level for the given level
+func slogLevel(level Level) slog.Level {
+	switch level {
+	case LevelDebug:
+		return slog.LevelDebug
+	case LevelInfo:
+		return slog.LevelInfo
+	case LevelWarn:
+		return slog.LevelWarn
+	}
+	return slog.LevelError
+}
+
+// Enabled implements Logger
+func (l slogLogger) Enabled(level Level) bool {
+	return l.l.Enabled(context.Background(), slogLevel(level))
+}
+
+// Log implements Logger
+func (l slogLogger) Log(level Level, msg string, fields ...Field) {
+	attrs := make([]slog.Attr, len(fields))
+	for i, f := range fields {
+		attrs[i] = slog.Any(f.Key, f.Value)
+	}
+	l.l.LogAttrs(context.Background(), slogLevel(level), msg, attrs...)
+}
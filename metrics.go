@@ -0,0 +1,161 @@
+package gosocketio
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/mtfelian/golang-socketio/metrics"
+	"github.com/mtfelian/golang-socketio/protocol"
+	"github.com/mtfelian/golang-socketio/transport"
+)
+
+// unknownEvent labels the metrics of the incoming events without handler,
+// so the clients can not flood the metrics with arbitrary event names
+const unknownEvent = "<unknown>"
+
+// serverMetrics represents the metrics of the server
+type serverMetrics struct {
+	registry *metrics.Registry
+
+	connections *metrics.CounterVec // by transport
+	upgrades    *metrics.CounterVec
+	disconnects *metrics.CounterVec // by reason
+
+	packetsIn  *metrics.CounterVec // by event name
+	bytesIn    *metrics.CounterVec // by event name
+	packetsOut *metrics.CounterVec // by event name
+	bytesOut   *metrics.CounterVec // by event name
+
+	ackDuration     *metrics.HistogramVec // by event name
+	handlerDuration *metrics.HistogramVec // by event name
+}
+
+// newServerMetrics creates the metrics of the server s
+func newServerMetrics(s *Server) *serverMetrics {
+	m := &serverMetrics{
+		registry: metrics.NewRegistry(),
+
+		connections: metrics.NewCounterVec("socketio_connections_total",
+			"Connections accepted by the initial transport.", "transport"),
+		upgrades: metrics.NewCounterVec("socketio_upgrades_total",
+			"Connections upgraded from polling to websocket.", ""),
+		disconnects: metrics.NewCounterVec("socketio_disconnects_total",
+			"Connections closed by the disconnect reason.", "reason"),
+
+		packetsIn: metrics.NewCounterVec("socketio_packets_received_total",
+			"Event packets received by the event name.", "event"),
+		bytesIn: metrics.NewCounterVec("socketio_bytes_received_total",
+			"Bytes of the event packets and their attachments received by the event name.", "event"),
+		packetsOut: metrics.NewCounterVec("socketio_packets_sent_total",
+			"Event packets queued to the channels by the event name.", "event"),
+		bytesOut: metrics.NewCounterVec("socketio_bytes_sent_total",
+			"Bytes of the event packets and their attachments queued to the channels by the event name.", "event"),
+
+		ackDuration: metrics.NewHistogramVec("socketio_ack_duration_seconds",
+			"Round trip of the ack requests sent by the server by the event name.", "event", nil),
+		handlerDuration: metrics.NewHistogramVec("socketio_handler_duration_seconds",
+			"Duration of the event handlers by the event name.", "event", nil),
+	}
+
+	m.registry.Register(
+		metrics.NewGaugeFunc("socketio_connected", "Connections by the current transport.", "transport",
+			func() map[string]float64 {
+				connected := make(map[string]float64)
+				for _, c := range s.channelsList() {
+					connected[transport.Name(c.connection())]++
+				}
+				return connected
+			}),
+		m.connections, m.upgrades, m.disconnects,
+		metrics.NewGaugeFunc("socketio_channels", "Channels connected to the namespace.", "namespace",
+			func() map[string]float64 { return s.countNamespaces((*Namespace).CountChannels) }),
+		metrics.NewGaugeFunc("socketio_rooms", "Rooms with at least one joined channel by the namespace.", "namespace",
+			func() map[string]float64 { return s.countNamespaces((*Namespace).CountRooms) }),
+		metrics.NewGaugeFunc("socketio_queue_depth", "Packets in the outgoing queues of all the connections.", "",
+			func() map[string]float64 {
+				var depth int
+				for _, c := range s.channelsList() {
+					depth += len(c.outC)
+				}
+				return map[string]float64{"": float64(depth)}
+			}),
+		metrics.NewGaugeFunc("socketio_overflooding_connections",
+			"Connections with the outgoing queue more than half full.", "",
+			func() map[string]float64 { return map[string]float64{"": float64(s.CountOverfloodingChannels())} }),
+		m.packetsIn, m.bytesIn, m.packetsOut, m.bytesOut,
+		m.ackDuration, m.handlerDuration,
+	)
+	return m
+}
+
+// MetricsHandler returns the HTTP handler exposing the server metrics in the Prometheus text format
+func (s *Server) MetricsHandler() http.Handler { return s.metrics.registry.Handler() }
+
+// countNamespaces returns the result of count for every namespace of the server by the namespace name
+func (s *Server) countNamespaces(count func(n *Namespace) int) map[string]float64 {
+	s.namespacesMu.RLock()
+	namespaces := make([]*Namespace, 0, len(s.namespaces)+1)
+	namespaces = append(namespaces, s.Namespace)
+	for _, n := range s.namespaces {
+		namespaces = append(namespaces, n)
+	}
+	s.namespacesMu.RUnlock()
+
+	counts := make(map[string]float64, len(namespaces))
+	for _, n := range namespaces {
+		counts[n.Name()] = float64(count(n))
+	}
+	return counts
+}
+
+// packetsSize returns the size of the packets in bytes
+func packetsSize(packets []string) int64 {
+	var size int64
+	for _, packet := range packets {
+		size += int64(len(packet))
+	}
+	return size
+}
+
+// countReceived counts the incoming event message m of the server channel c with handlers e
+func (c *Channel) countReceived(e *event, m *protocol.Message) {
+	if c.server == nil {
+		return
+	}
+
+	name := m.EventName
+	if _, ok := e.findHandler(name); !ok {
+		name = unknownEvent
+	}
+
+	size := int64(len(m.Source))
+	for _, attachment := range m.Attachments {
+		size += int64(len(attachment))
+	}
+	c.server.metrics.packetsIn.Inc(name)
+	c.server.metrics.bytesIn.Add(name, size)
+}
+
+// countSent counts the packets of the event with the given name queued to the server channel c
+func (c *Channel) countSent(name string, packets []string) {
+	if c.server == nil {
+		return
+	}
+	c.server.metrics.packetsOut.Inc(name)
+	c.server.metrics.bytesOut.Add(name, packetsSize(packets))
+}
+
+// observeAck observes the round trip of the ack request with the given name sent by the server channel c at start
+func (c *Channel) observeAck(name string, start time.Time) {
+	if c.server != nil {
+		c.server.metrics.ackDuration.Observe(name, time.Since(start).Seconds())
+	}
+}
+
+// observeHandler observes the duration of the handler of the event with the given name started at start
+// on the server channel c
+func (c *Channel) observeHandler(name string, start time.Time) {
+	if c.server != nil {
+		c.server.metrics.handlerDuration.Observe(name, time.Since(start).Seconds())
+	}
+}
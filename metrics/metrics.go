@@ -0,0 +1,277 @@
+// Package metrics implements counters, gauges and histograms exposed in the Prometheus text format
+// without the Prometheus client library. Every metric has at most one label.
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are the default histogram buckets in seconds, fitting the network and handler latencies
+var DefaultBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Collector is a metric written by the registry
+type Collector interface {
+	write(w *bufio.Writer)
+}
+
+// Registry represents a set of metrics written together
+type Registry struct {
+	collectors []Collector
+	mu         sync.RWMutex
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry { return &Registry{} }
+
+// Register adds the metrics to the registry, they are written in the order of registration
+func (r *Registry) Register(collectors ...Collector) {
+	r.mu.Lock()
+	r.collectors = append(r.collectors, collectors...)
+	r.mu.Unlock()
+}
+
+// Write all the metrics of the registry to w in the Prometheus text format
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.RLock()
+	collectors := r.collectors
+	r.mu.RUnlock()
+
+	bw := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler returns the HTTP handler writing the metrics of the registry
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		r.Write(w)
+	})
+}
+
+// desc describes the metric
+type desc struct {
+	name  string
+	help  string
+	label string // label name, empty for the metric without labels
+}
+
+// writeHeader writes the HELP and TYPE lines of the metric
+func (d desc) writeHeader(w *bufio.Writer, typ string) {
+	w.WriteString("# HELP " + d.name + " " + escapeHelp(d.help) + "\n")
+	w.WriteString("# TYPE " + d.name + " " + typ + "\n")
+}
+
+// writeSample writes the sample of the metric with the given name suffix, label value and value,
+// extra is a label pair added after the metric label
+func (d desc) writeSample(w *bufio.Writer, suffix, value string, extra string, v float64) {
+	w.WriteString(d.name + suffix)
+	switch {
+	case d.label != "" && extra != "":
+		w.WriteString("{" + d.label + `="` + escapeLabel(value) + `",` + extra + "}")
+	case d.label != "":
+		w.WriteString("{" + d.label + `="` + escapeLabel(value) + `"}`)
+	case extra != "":
+		w.WriteString("{" + extra + "}")
+	}
+	w.WriteString(" " + formatFloat(v) + "\n")
+}
+
+// CounterVec represents the counters partitioned by the label values
+type CounterVec struct {
+	desc
+	values map[string]*int64
+	mu     sync.RWMutex
+}
+
+// NewCounterVec creates the counters with the given name, help and label name, empty for no label
+func NewCounterVec(name, help, label string) *CounterVec {
+	return &CounterVec{desc: desc{name: name, help: help, label: label}, values: make(map[string]*int64)}
+}
+
+// Add n to the counter with the given label value
+func (v *CounterVec) Add(value string, n int64) {
+	v.mu.RLock()
+	counter, ok := v.values[value]
+	v.mu.RUnlock()
+
+	if !ok {
+		v.mu.Lock()
+		if counter, ok = v.values[value]; !ok {
+			counter = new(int64)
+			v.values[value] = counter
+		}
+		v.mu.Unlock()
+	}
+	atomic.AddInt64(counter, n)
+}
+
+// Inc increments the counter with the given label value
+func (v *CounterVec) Inc(value string) { v.Add(value, 1) }
+
+// Get returns the counter value with the given label value
+func (v *CounterVec) Get(value string) int64 {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if counter, ok := v.values[value]; ok {
+		return atomic.LoadInt64(counter)
+	}
+	return 0
+}
+
+// write implements Collector
+func (v *CounterVec) write(w *bufio.Writer) {
+	v.writeHeader(w, "counter")
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	values := make([]string, 0, len(v.values))
+	for value := range v.values {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+
+	for _, value := range values {
+		v.writeSample(w, "", value, "", float64(atomic.LoadInt64(v.values[value])))
+	}
+}
+
+// GaugeFunc represents the gauges partitioned by the label values, read when the metrics are written
+type GaugeFunc struct {
+	desc
+	f func() map[string]float64
+}
+
+// NewGaugeFunc creates the gauges with the given name, help and label name, empty for no label.
+// f returns the gauge values by the label values, the value of the gauge without label has the empty key.
+func NewGaugeFunc(name, help, label string, f func() map[string]float64) *GaugeFunc {
+	return &GaugeFunc{desc: desc{name: name, help: help, label: label}, f: f}
+}
+
+// write implements Collector
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	g.writeHeader(w, "gauge")
+	gauges := g.f()
+	values := make([]string, 0, len(gauges))
+	for value := range gauges {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+
+	for _, value := range values {
+		g.writeSample(w, "", value, "", gauges[value])
+	}
+}
+
+// histogram represents the histogram with the given buckets
+type histogram struct {
+	counts []uint64 // observations in every bucket, not cumulative, the last one is +Inf
+	count  uint64
+	sum    uint64 // bits of the float64 sum
+}
+
+// observe the value x
+func (h *histogram) observe(buckets []float64, x float64) {
+	i := sort.SearchFloat64s(buckets, x)
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.count, 1)
+	for {
+		old := atomic.LoadUint64(&h.sum)
+		if atomic.CompareAndSwapUint64(&h.sum, old, math.Float64bits(math.Float64frombits(old)+x)) {
+			return
+		}
+	}
+}
+
+// HistogramVec represents the histograms partitioned by the label values
+type HistogramVec struct {
+	desc
+	buckets []float64
+	values  map[string]*histogram
+	mu      sync.RWMutex
+}
+
+// NewHistogramVec creates the histograms with the given name, help, label name, empty for no label,
+// and the sorted upper bounds of the buckets, DefaultBuckets if nil
+func NewHistogramVec(name, help, label string, buckets []float64) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	return &HistogramVec{desc: desc{name: name, help: help, label: label}, buckets: buckets,
+		values: make(map[string]*histogram)}
+}
+
+// Observe the value x by the histogram with the given label value
+func (v *HistogramVec) Observe(value string, x float64) {
+	v.mu.RLock()
+	h, ok := v.values[value]
+	v.mu.RUnlock()
+
+	if !ok {
+		v.mu.Lock()
+		if h, ok = v.values[value]; !ok {
+			h = &histogram{counts: make([]uint64, len(v.buckets)+1)}
+			v.values[value] = h
+		}
+		v.mu.Unlock()
+	}
+	h.observe(v.buckets, x)
+}
+
+// write implements Collector
+func (v *HistogramVec) write(w *bufio.Writer) {
+	v.writeHeader(w, "histogram")
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	values := make([]string, 0, len(v.values))
+	for value := range v.values {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+
+	for _, value := range values {
+		h := v.values[value]
+		var cumulative uint64
+		for i, bound := range v.buckets {
+			cumulative += atomic.LoadUint64(&h.counts[i])
+			v.writeSample(w, "_bucket", value, `le="`+formatFloat(bound)+`"`, float64(cumulative))
+		}
+		cumulative += atomic.LoadUint64(&h.counts[len(v.buckets)])
+		v.writeSample(w, "_bucket", value, `le="+Inf"`, float64(cumulative))
+		v.writeSample(w, "_sum", value, "", math.Float64frombits(atomic.LoadUint64(&h.sum)))
+		v.writeSample(w, "_count", value, "", float64(cumulative))
+	}
+}
+
+// formatFloat formats v for the text format
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpReplacer  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+// escapeLabel escapes the label value for the text format
+func escapeLabel(s string) string { return labelReplacer.Replace(s) }
+
+// escapeHelp escapes the help text for the text format
+func escapeHelp(s string) string { return helpReplacer.Replace(s) }
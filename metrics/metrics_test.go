@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	requests := NewCounterVec("test_requests_total", "Requests by the path.\nEscaped \\ help.", "path")
+	requests.Inc("/b")
+	requests.Add("/a", 2)
+	requests.Inc("quote\" backslash\\ newline\n")
+
+	failures := NewCounterVec("test_errors_total", "Errors.", "")
+	failures.Inc("")
+
+	gauge := NewGaugeFunc("test_connected", "Connections by the transport.", "transport",
+		func() map[string]float64 { return map[string]float64{"websocket": 2, "polling": 1} })
+
+	durations := NewHistogramVec("test_duration_seconds", "Durations by the event.", "event", []float64{0.1, 1})
+	for _, x := range []float64{0.25, 0.05, 2, 0.5} {
+		durations.Observe("a", x)
+	}
+	durations.Observe("b", 0.1) // the upper bound is inclusive
+
+	plain := NewHistogramVec("test_plain_seconds", "Durations.", "", []float64{1})
+	plain.Observe("", 3)
+
+	r := NewRegistry()
+	r.Register(requests, failures, gauge, durations, plain)
+	var buf bytes.Buffer
+	if err := r.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP test_requests_total Requests by the path.\nEscaped \\ help.
+# TYPE test_requests_total counter
+test_requests_total{path="/a"} 2
+test_requests_total{path="/b"} 1
+test_requests_total{path="quote\" backslash\\ newline\n"} 1
+# HELP test_errors_total Errors.
+# TYPE test_errors_total counter
+test_errors_total 1
+# HELP test_connected Connections by the transport.
+# TYPE test_connected gauge
+test_connected{transport="polling"} 1
+test_connected{transport="websocket"} 2
+# HELP test_duration_seconds Durations by the event.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{event="a",le="0.1"} 1
+test_duration_seconds_bucket{event="a",le="1"} 3
+test_duration_seconds_bucket{event="a",le="+Inf"} 4
+test_duration_seconds_sum{event="a"} 2.8
+test_duration_seconds_count{event="a"} 4
+test_duration_seconds_bucket{event="b",le="0.1"} 1
+test_duration_seconds_bucket{event="b",le="1"} 1
+test_duration_seconds_bucket{event="b",le="+Inf"} 1
+test_duration_seconds_sum{event="b"} 0.1
+test_duration_seconds_count{event="b"} 1
+# HELP test_plain_seconds Durations.
+# TYPE test_plain_seconds histogram
+test_plain_seconds_bucket{le="1"} 0
+test_plain_seconds_bucket{le="+Inf"} 1
+test_plain_seconds_sum 3
+test_plain_seconds_count 1
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,46 @@
+package gosocketio
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// scrape returns the metrics of the server s
+func scrape(t *testing.T, s *Server) string {
+	w := httptest.NewRecorder()
+	s.MetricsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(w.Result().Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestMetricsUnknownEvent(t *testing.T) {
+	s := newTestServer()
+	var handled int32
+	s.On("known", func(c *Channel) { atomic.AddInt32(&handled, 1) })
+
+	c := dialTest(t, serveTest(t, s), false)
+	for _, name := range []string{"random1", "random2", "known"} {
+		if err := c.Emit(name, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, func() bool { return atomic.LoadInt32(&handled) == 1 })
+	// the events without handler are counted before the handled one
+	waitFor(t, func() bool {
+		return strings.Contains(scrape(t, s), `socketio_packets_received_total{event="known"} 1`+"\n")
+	})
+
+	metrics := scrape(t, s)
+	if want := `socketio_packets_received_total{event="<unknown>"} 2` + "\n"; !strings.Contains(metrics, want) {
+		t.Errorf("got metrics:\n%s\nwant %q", metrics, want)
+	}
+	if strings.Contains(metrics, "random") {
+		t.Errorf("got metrics:\n%s\nwith the event names without handler", metrics)
+	}
+}
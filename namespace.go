@@ -0,0 +1,134 @@
+package gosocketio
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/mtfelian/golang-socketio/protocol"
+)
+
+// Namespace represents a socket.io namespace served by the server
+type Namespace struct {
+	*event
+
+	name   string
+	server *Server
+
+	adapter   Adapter
+	adapterMu sync.RWMutex
+
+	sids   map[string]*Channel // maps channel id to channel
+	sidsMu sync.RWMutex
+}
+
+// newNamespace creates new namespace with the given name on server s
+func newNamespace(s *Server, name string) *Namespace {
+	n := &Namespace{
+		name:   name,
+		server: s,
+		sids:   make(map[string]*Channel),
+		event: &event{
+			onConnection:    onConnection,
+			onDisconnection: onDisconnection,
+		},
+	}
+	n.event.init()
+	n.adapter = s.newAdapter(n)
+	return n
+}
+
+// namespaceName normalizes the given namespace name
+func namespaceName(name string) string {
+	if protocol.IsDefaultNamespace(name) {
+		return protocol.DefaultNamespace
+	}
+	if !strings.HasPrefix(name, "/") {
+		return "/" + name
+	}
+	return name
+}
+
+// Name returns the namespace name
+func (n *Namespace) Name() string { return n.name }
+
+// GetChannel by it's sid
+func (n *Namespace) GetChannel(sid string) (*Channel, error) {
+	n.sidsMu.RLock()
+	defer n.sidsMu.RUnlock()
+
+	c, ok := n.sids[sid]
+	if !ok {
+		return nil, ErrorConnectionNotFound
+	}
+
+	return c, nil
+}
+
+// Adapter returns the adapter managing rooms of the namespace
+func (n *Namespace) Adapter() Adapter {
+	n.adapterMu.RLock()
+	defer n.adapterMu.RUnlock()
+	return n.adapter
+}
+
+// setAdapter replaces the adapter managing rooms of the namespace
+func (n *Namespace) setAdapter(a Adapter) {
+	n.adapterMu.Lock()
+	n.adapter = a
+	n.adapterMu.Unlock()
+}
+
+// channelsList returns a list of the channels connected to the namespace
+func (n *Namespace) channelsList() []*Channel {
+	n.sidsMu.RLock()
+	defer n.sidsMu.RUnlock()
+
+	channels := make([]*Channel, 0, len(n.sids))
+	for _, c := range n.sids {
+		channels = append(channels, c)
+	}
+	return channels
+}
+
+// Get amount of channels, joined to given room, using namespace
+func (n *Namespace) Amount(room string) int { return n.Adapter().Amount(room) }
+
+// List returns a list of channels joined to the given room, using namespace
+func (n *Namespace) List(room string) []*Channel { return n.Adapter().List(room) }
+
+// BroadcastTo the the given room an handler with payload, using namespace
+func (n *Namespace) BroadcastTo(room, name string, payload interface{}) {
+	n.Adapter().Broadcast(BroadcastOptions{Rooms: []string{room}}, name, payload)
+}
+
+// Broadcast to all clients of the namespace
+func (n *Namespace) BroadcastToAll(method string, payload interface{}) {
+	n.Adapter().Broadcast(BroadcastOptions{}, method, payload)
+}
+
+// CountChannels returns an amount of channels connected to the namespace
+func (n *Namespace) CountChannels() int {
+	n.sidsMu.RLock()
+	defer n.sidsMu.RUnlock()
+	return len(n.sids)
+}
+
+// CountRooms returns an amount of rooms with at least one joined channel
+func (n *Namespace) CountRooms() int { return n.Adapter().CountRooms() }
+
+// onConnection fires on connection
+func onConnection(c *Channel) {
+	c.namespace.sidsMu.Lock()
+	c.namespace.sids[c.Id()] = c
+	c.namespace.sidsMu.Unlock()
+}
+
+// onDisconnection fires on disconnection
+func onDisconnection(c *Channel) {
+	n := c.namespace
+	n.Adapter().LeaveAll(c)
+
+	n.sidsMu.Lock()
+	delete(n.sids, c.Id())
+	n.sidsMu.Unlock()
+}
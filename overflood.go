@@ -1,17 +1,228 @@
 package gosocketio
 
 import (
-	"sync"
+	"context"
+
+	"github.com/mtfelian/golang-socketio/logging"
+	"github.com/mtfelian/golang-socketio/protocol"
 )
 
-var (
-	overflooded   = make(map[*Channel]struct{})
-	overfloodedMu sync.Mutex
+// OverfloodPolicy defines what happens to the message sent to the channel with the full outgoing queue
+type OverfloodPolicy int
+
+const (
+	OverfloodDisconnect OverfloodPolicy = iota // the message is dropped and the channel is closed
+	OverfloodDropNewest                        // the message is dropped
+	OverfloodDropOldest                        // the oldest queued event without ack is dropped to make room for the message
+	OverfloodBlock                             // the sender waits for room until it's context is done, broadcasts drop the message
 )
 
-// CountOverfloodingChannels returns an amount of overflooding channels
-func CountOverfloodingChannels() int {
-	overfloodedMu.Lock()
-	defer overfloodedMu.Unlock()
-	return len(overflooded)
+// QueueParams configures the outgoing queue of the channels
+type QueueParams struct {
+	// Size is a maximum amount of packets queued by the channel, 500 if zero.
+	// Dropping the oldest event may keep up to Size control packets more.
+	Size   int
+	Policy OverfloodPolicy // what happens to the messages sent to the full queue
+	// VolatileThreshold is an amount of queued packets above which the volatile packets are dropped, Size/2 if zero
+	VolatileThreshold int
+}
+
+// volatileThreshold returns an amount of queued packets above which the volatile packets are dropped
+func (p QueueParams) volatileThreshold() int {
+	if p.VolatileThreshold <= 0 {
+		return p.Size / 2
+	}
+	return p.VolatileThreshold
+}
+
+// SetQueue sets the outgoing queue params for the new connections, see QueueParams.
+// By default the queue holds 500 packets and the channel with the full queue is closed.
+func (s *Server) SetQueue(params QueueParams) {
+	if params.Size <= 0 {
+		params.Size = queueBufferSize
+	}
+
+	s.queueMu.Lock()
+	s.queue = params
+	s.queueMu.Unlock()
+}
+
+// queueParams returns the outgoing queue params for the new connections
+func (s *Server) queueParams() QueueParams {
+	s.queueMu.RLock()
+	defer s.queueMu.RUnlock()
+	return s.queue
+}
+
+// OnOverflood sets the handler called when the message is sent to the channel with the full outgoing queue,
+// it's called before the queue policy applies
+func (s *Server) OnOverflood(f func(c *Channel)) {
+	s.overfloodedMu.Lock()
+	s.onOverflood = f
+	s.overfloodedMu.Unlock()
+}
+
+// CountOverfloodingChannels returns an amount of channels with the outgoing queue more than half full
+func (s *Server) CountOverfloodingChannels() int {
+	s.overfloodedMu.RLock()
+	defer s.overfloodedMu.RUnlock()
+	return len(s.overflooded)
+}
+
+// setOverflooding marks the root channel c as overflooding or not
+func (s *Server) setOverflooding(c *Channel, overflooding bool) {
+	s.overfloodedMu.Lock()
+	defer s.overfloodedMu.Unlock()
+
+	if overflooding {
+		s.overflooded[c] = struct{}{}
+	} else {
+		delete(s.overflooded, c)
+	}
+}
+
+// overflood calls the overflood handler for the channel c
+func (s *Server) overflood(c *Channel) {
+	s.overfloodedMu.RLock()
+	f := s.onOverflood
+	s.overfloodedMu.RUnlock()
+
+	if f != nil {
+		f(c)
+	}
+}
+
+// enqueue the packet with it's binary attachments to the outgoing queue of the channel c,
+// applying the queue policy if it's full. Blocking policy waits until ctx is done or the channel is closed.
+func (c *Channel) enqueue(ctx context.Context, packets []string) error {
+	return c.enqueuePolicy(ctx, packets, c.queue.Policy)
+}
+
+// enqueueBroadcast queues the broadcast packets of the event with the given name to the channel c.
+// Broadcasts never wait for room in the queue, so one slow channel does not stall the others:
+// the blocking policy drops the packets for them.
+func (c *Channel) enqueueBroadcast(name string, packets []string, volatile bool) {
+	if volatile {
+		if c.enqueueVolatile(packets) {
+			c.countSent(name, packets)
+		}
+		return
+	}
+
+	policy := c.queue.Policy
+	if policy == OverfloodBlock {
+		policy = OverfloodDropNewest
+	}
+	if err := c.enqueuePolicy(context.Background(), packets, policy); err != nil {
+		c.log().Debug("Channel.enqueueBroadcast() skips channel", logging.F(logging.KeyEvent, name), logging.Err(err))
+		return
+	}
+	c.countSent(name, packets)
+}
+
+// enqueuePolicy queues the packets to the outgoing queue of the channel c applying the given policy if it's full
+func (c *Channel) enqueuePolicy(ctx context.Context, packets []string, policy OverfloodPolicy) error {
+	select {
+	case c.outC <- packets:
+		return nil
+	default:
+	}
+
+	select { // aliveMu may be locked by the caller closing the channel
+	case <-c.root().outDoneC:
+		return ErrorClientNotConnected
+	default:
+	}
+	if c.server != nil {
+		c.server.overflood(c)
+	}
+
+	switch policy {
+	case OverfloodBlock:
+		select {
+		case c.outC <- packets:
+			return nil
+		case <-c.root().outDoneC:
+			return ErrorClientNotConnected
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+	case OverfloodDropOldest:
+		return c.dropOldest(packets)
+
+	case OverfloodDisconnect:
+		root := c.root()
+		go root.close(root.events, DisconnectOverflood)
+	}
+	return ErrorSocketOverflood
+}
+
+// dropOldest queues the packets to the full outgoing queue of the channel c dropping the oldest event without ack.
+// The control packets and the acks queued before it are moved to the head of the queue keeping their order.
+func (c *Channel) dropOldest(packets []string) error {
+	root := c.root()
+	select {
+	case c.outC <- packets: // the queue was read meanwhile
+		return nil
+	case root.outLockC <- struct{}{}:
+	case <-root.outDoneC:
+		return ErrorClientNotConnected
+	}
+	defer func() { <-root.outLockC }()
+
+	for len(root.outHead) < cap(c.outC) {
+		select {
+		case oldest := <-c.outC:
+			if !droppable(oldest[0]) {
+				root.outHead = append(root.outHead, oldest)
+				continue
+			}
+		default: // the queue was read meanwhile
+		}
+
+		select {
+		case c.outC <- packets:
+			return nil
+		default: // the room was taken by another sender
+		}
+	}
+
+	select { // the head is full of control packets
+	case c.outC <- packets:
+		return nil
+	default:
+		return ErrorSocketOverflood
+	}
+}
+
+// dequeue returns the next packet of the outgoing queue of the root channel c, the packets moved to the head
+// of the queue come first. If wait is false and there are no packets returns false.
+func (c *Channel) dequeue(wait bool) ([]string, bool) {
+	if c.queue.Policy == OverfloodDropOldest {
+		c.outLockC <- struct{}{} // the sender dropping the oldest event doesn't wait for the token
+		defer func() { <-c.outLockC }()
+
+		if len(c.outHead) > 0 {
+			m := c.outHead[0]
+			c.outHead = c.outHead[1:]
+			return m, true
+		}
+	}
+
+	if wait {
+		return <-c.outC, true
+	}
+	select {
+	case m := <-c.outC:
+		return m, true
+	default:
+		return nil, false
+	}
+}
+
+// droppable returns true if the queued packet is an event without ack
+func droppable(packet string) bool {
+	m, err := protocol.Decode(packet)
+	return err == nil && m.Type == protocol.MessageTypeEmit
 }
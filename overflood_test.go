@@ -0,0 +1,122 @@
+package gosocketio
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mtfelian/golang-socketio/protocol"
+)
+
+// within fails the test if f does not return in time
+func within(t *testing.T, timeout time.Duration, f func()) {
+	doneC := make(chan struct{})
+	go func() {
+		defer close(doneC)
+		f()
+	}()
+
+	select {
+	case <-doneC:
+	case <-time.After(timeout):
+		t.Fatal("timed out")
+	}
+}
+
+func TestCloseNamespaceFullQueue(t *testing.T) {
+	for _, policy := range []OverfloodPolicy{OverfloodDisconnect, OverfloodDropNewest, OverfloodDropOldest} {
+		s, channels := newTestChannels(1, QueueParams{Size: 1, Policy: policy})
+		c := channels[0]
+		n := s.Of("/chat")
+		nc := c.addNamespaceChannel(n.name, n.event)
+		nc.namespace = n
+
+		c.outC <- []string{protocol.MessagePing}
+		within(t, time.Second, func() { nc.Close() })
+		if nc.IsAlive() {
+			t.Errorf("policy %d: namespace channel is alive", policy)
+		}
+	}
+}
+
+func TestBroadcastFullQueueBlock(t *testing.T) {
+	s, channels := newTestChannels(3, QueueParams{Size: 1, Policy: OverfloodBlock})
+	channels[0].outC <- []string{protocol.MessagePing}
+
+	within(t, time.Second, func() { s.BroadcastTo("room", "message", 1) })
+	if got := queuedEvents(t, channels[0], "message"); len(got) != 0 {
+		t.Errorf("got events %v in the full queue, want none", got)
+	}
+	for i, c := range channels[1:] {
+		if got := queuedEvents(t, c, "message"); len(got) != 1 {
+			t.Errorf("channel %d: got events %v, want one", i+1, got)
+		}
+	}
+}
+
+func TestDropOldestKeepsOrder(t *testing.T) {
+	_, channels := newTestChannels(1, QueueParams{Size: 4, Policy: OverfloodDropOldest})
+	c := channels[0]
+	drain(channels)
+
+	queued := [][]string{
+		{protocol.MessagePing},
+		{protocol.MustEncode(&protocol.Message{Type: protocol.MessageTypeAckResponse, AckID: 1, Args: "1"})},
+		{protocol.MustEncode(&protocol.Message{Type: protocol.MessageTypeEmit, EventName: "seq", Args: "1"})},
+		{protocol.MustEncode(&protocol.Message{Type: protocol.MessageTypeAckRequest, AckID: 2, EventName: "seq",
+			Args: "2"})},
+	}
+	for _, packets := range queued {
+		c.outC <- packets
+	}
+
+	if err := c.Emit("seq", 3); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{queued[0][0], queued[1][0], queued[3][0],
+		protocol.MustEncode(&protocol.Message{Type: protocol.MessageTypeEmit, EventName: "seq", Args: "3"})}
+	var got []string
+	for {
+		packets, ok := c.dequeue(false)
+		if !ok {
+			break
+		}
+		got = append(got, packets[0])
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got packets %q, want %q", got, want)
+	}
+}
+
+func TestDropOldestControlPackets(t *testing.T) {
+	_, channels := newTestChannels(1, QueueParams{Size: 2, Policy: OverfloodDropOldest})
+	c := channels[0]
+	drain(channels)
+
+	for i := 0; i < 2; i++ {
+		c.outC <- []string{protocol.MessagePing}
+	}
+	if err := c.Emit("seq", 1); err != nil { // the pings are moved to the head of the queue
+		t.Fatal(err)
+	}
+	c.outC <- []string{protocol.MessagePing}
+	if err := c.Emit("seq", 2); err != ErrorSocketOverflood {
+		t.Errorf("got error %v, want %v", err, ErrorSocketOverflood)
+	}
+
+	var got []string
+	for {
+		packets, ok := c.dequeue(false)
+		if !ok {
+			break
+		}
+		got = append(got, packets[0])
+	}
+	want := []string{protocol.MessagePing, protocol.MessagePing,
+		protocol.MustEncode(&protocol.Message{Type: protocol.MessageTypeEmit, EventName: "seq", Args: "1"}),
+		protocol.MessagePing}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got packets %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,311 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MessageBinary prefixes an engine.io binary message, the rest of the string is raw binary data
+const MessageBinary = "\x04"
+
+// placeholder represents binary attachment position in the packet data
+type placeholder struct {
+	Placeholder bool `json:"_placeholder"`
+	Num         int  `json:"num"`
+}
+
+var (
+	marshalerType     = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+	binaryTypes sync.Map // maps reflect.Type to bool, true if values of the type may contain binary data
+)
+
+// IsBinary returns true if the given engine.io message m is a binary message
+func IsBinary(m string) bool { return strings.HasPrefix(m, MessageBinary) }
+
+// EncodeBinary returns an engine.io binary message with the given data
+func EncodeBinary(data []byte) string { return MessageBinary + string(data) }
+
+// DecodeBinary returns data of the given engine.io binary message m
+func DecodeBinary(m string) []byte { return []byte(m[len(MessageBinary):]) }
+
+// Deconstruct replaces []byte values found in the given payload v with placeholders,
+// returns the resulting payload and binary attachments to send following the packet.
+// If v can't contain []byte values it is returned as is.
+func Deconstruct(v interface{}) (interface{}, [][]byte) {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() || !mayHaveBinary(rv.Type()) {
+		return v, nil
+	}
+
+	var attachments [][]byte
+	result := deconstruct(rv, &attachments)
+	if len(attachments) == 0 {
+		return v, nil
+	}
+	return result, attachments
+}
+
+// Reconstruct replaces placeholders in the given packet args with base64 encoded binary attachments,
+// so they could be unmarshaled into []byte values
+func Reconstruct(args string, attachments [][]byte) (string, error) {
+	if len(attachments) == 0 {
+		return args, nil
+	}
+
+	decoder := json.NewDecoder(strings.NewReader("[" + args + "]"))
+	decoder.UseNumber()
+
+	var data interface{}
+	if err := decoder.Decode(&data); err != nil {
+		return "", err
+	}
+
+	data, err := reconstruct(data, attachments, func(attachment []byte) interface{} {
+		return base64.StdEncoding.EncodeToString(attachment)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	b, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	return string(b[1 : len(b)-1]), nil
+}
+
+// Rebuild returns the payload deconstructed by Deconstruct from it's JSON data and binary attachments,
+// placeholders are replaced with []byte values, so the payload could be deconstructed again.
+// Payload without attachments is returned as json.RawMessage.
+func Rebuild(data []byte, attachments [][]byte) (interface{}, error) {
+	if len(attachments) == 0 {
+		return json.RawMessage(data), nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var payload interface{}
+	if err := decoder.Decode(&payload); err != nil {
+		return nil, err
+	}
+	return reconstruct(payload, attachments, func(attachment []byte) interface{} { return attachment })
+}
+
+// reconstruct replaces placeholders in the decoded data with the attachments converted by replace
+func reconstruct(data interface{}, attachments [][]byte, replace func([]byte) interface{}) (interface{}, error) {
+	switch value := data.(type) {
+	case []interface{}:
+		for i := range value {
+			item, err := reconstruct(value[i], attachments, replace)
+			if err != nil {
+				return nil, err
+			}
+			value[i] = item
+		}
+	case map[string]interface{}:
+		if isPlaceholder, _ := value["_placeholder"].(bool); isPlaceholder {
+			n, _ := value["num"].(json.Number)
+			num, err := n.Int64()
+			if err != nil || num < 0 || int(num) >= len(attachments) {
+				return nil, ErrorWrongPacket
+			}
+			return replace(attachments[num]), nil
+		}
+		for k := range value {
+			item, err := reconstruct(value[k], attachments, replace)
+			if err != nil {
+				return nil, err
+			}
+			value[k] = item
+		}
+	}
+	return data, nil
+}
+
+// mayHaveBinary returns true if values of the given type t may contain []byte values
+func mayHaveBinary(t reflect.Type) bool {
+	if result, ok := binaryTypes.Load(t); ok {
+		return result.(bool)
+	}
+	result := typeMayHaveBinary(t, make(map[reflect.Type]struct{}))
+	binaryTypes.Store(t, result)
+	return result
+}
+
+// typeMayHaveBinary checks the type t recursively, visited prevents infinite recursion on recursive types
+func typeMayHaveBinary(t reflect.Type, visited map[reflect.Type]struct{}) bool {
+	if _, ok := visited[t]; ok {
+		return false
+	}
+	visited[t] = struct{}{}
+
+	if t.Implements(marshalerType) || reflect.PtrTo(t).Implements(marshalerType) {
+		return false
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return true
+		}
+		return typeMayHaveBinary(t.Elem(), visited)
+	case reflect.Array, reflect.Ptr, reflect.Map:
+		return typeMayHaveBinary(t.Elem(), visited)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if f := t.Field(i); f.PkgPath == "" && typeMayHaveBinary(f.Type, visited) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// deconstruct the value v into JSON-marshalable representation, collecting binary attachments
+func deconstruct(v reflect.Value, attachments *[][]byte) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	if !mayHaveBinary(v.Type()) {
+		return v.Interface()
+	}
+
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		return deconstruct(v.Elem(), attachments)
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			*attachments = append(*attachments, v.Bytes())
+			return placeholder{Placeholder: true, Num: len(*attachments) - 1}
+		}
+		fallthrough
+	case reflect.Array:
+		result := make([]interface{}, v.Len())
+		for i := range result {
+			result[i] = deconstruct(v.Index(i), attachments)
+		}
+		return result
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		result := make(map[string]interface{}, v.Len())
+		for _, k := range v.MapKeys() {
+			result[mapKey(k)] = deconstruct(v.MapIndex(k), attachments)
+		}
+		return result
+	case reflect.Struct:
+		result := make(map[string]interface{})
+		deconstructStruct(v, result, attachments)
+		return result
+	}
+	return v.Interface()
+}
+
+// deconstructStruct puts fields of the struct v into result following encoding/json naming rules
+func deconstructStruct(v reflect.Value, result map[string]interface{}, attachments *[][]byte) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" { // unexported
+			continue
+		}
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, options := tag, ""
+		if pos := strings.IndexByte(tag, ','); pos != -1 {
+			name, options = tag[:pos], tag[pos+1:]
+		}
+
+		fv := v.Field(i)
+		if f.Anonymous && name == "" {
+			if fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					continue
+				}
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct {
+				deconstructStruct(fv, result, attachments)
+				continue
+			}
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+		if strings.Contains(options, "omitempty") && isEmptyValue(fv) {
+			continue
+		}
+		result[name] = deconstruct(fv, attachments)
+	}
+}
+
+// mapKey returns a string representation of the map key k as encoding/json does
+func mapKey(k reflect.Value) string {
+	if k.Kind() == reflect.String {
+		return k.String()
+	}
+	if k.Type().Implements(textMarshalerType) {
+		if b, err := k.Interface().(encoding.TextMarshaler).MarshalText(); err == nil {
+			return string(b)
+		}
+	}
+	return fmt.Sprint(k.Interface())
+}
+
+// isEmptyValue reports whether v is empty in the sense of the encoding/json omitempty option
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// binaryCount parses the amount of binary attachments prefix "N-" of the text,
+// returns the amount and the rest of the text
+func binaryCount(text string) (int, string, error) {
+	pos := strings.IndexByte(text, '-')
+	if pos == -1 {
+		return 0, "", ErrorWrongPacket
+	}
+
+	count, err := strconv.Atoi(text[:pos])
+	if err != nil || count < 0 {
+		return 0, "", ErrorWrongPacket
+	}
+	return count, text[pos+1:], nil
+}
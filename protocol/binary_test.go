@@ -0,0 +1,210 @@
+package protocol
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeBinaryPackets(t *testing.T) {
+	tests := []struct {
+		name string
+		m    Message
+		want string
+	}{
+		{"binary event", Message{Type: MessageTypeEmit, EventName: "file", Args: `{"_placeholder":true,"num":0}`,
+			Attachments: [][]byte{{1}}}, `451-["file",{"_placeholder":true,"num":0}]`},
+		{"binary event namespace", Message{Type: MessageTypeEmit, Namespace: "/chat", EventName: "file",
+			Args: `1`, Attachments: [][]byte{{1}, {2}}}, `452-/chat,["file",1]`},
+		{"binary ack request", Message{Type: MessageTypeAckRequest, AckID: 5, EventName: "file", Args: `1`,
+			Attachments: [][]byte{{1}}}, `451-5["file",1]`},
+		{"binary ack response", Message{Type: MessageTypeAckResponse, AckID: 5, Args: `1`,
+			Attachments: [][]byte{{1}}}, `461-5[1]`},
+		{"binary ack response namespace", Message{Type: MessageTypeAckResponse, Namespace: "/chat", AckID: 5,
+			Args: `1`, Attachments: [][]byte{{1}}}, `461-/chat,5[1]`},
+	}
+
+	for _, tt := range tests {
+		got, err := Encode(&tt.m)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeBinaryPackets(t *testing.T) {
+	tests := []struct {
+		data string
+		want Message
+	}{
+		{`451-["file",{"_placeholder":true,"num":0}]`, Message{Type: MessageTypeEmit, EventName: "file",
+			Args: `{"_placeholder":true,"num":0}`, AttachmentsCount: 1}},
+		{`452-/chat,["file",1]`, Message{Type: MessageTypeEmit, Namespace: "/chat", EventName: "file", Args: "1",
+			AttachmentsCount: 2}},
+		{`451-5["file",1]`, Message{Type: MessageTypeAckRequest, AckID: 5, EventName: "file", Args: "1",
+			AttachmentsCount: 1}},
+		{`461-5[1]`, Message{Type: MessageTypeAckResponse, AckID: 5, Args: "1", AttachmentsCount: 1}},
+		{`461-/chat,5[1]`, Message{Type: MessageTypeAckResponse, Namespace: "/chat", AckID: 5, Args: "1",
+			AttachmentsCount: 1}},
+	}
+
+	for _, tt := range tests {
+		got, err := Decode(tt.data)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.data, err)
+			continue
+		}
+		tt.want.Source = tt.data
+		if !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("%q: got %+v, want %+v", tt.data, *got, tt.want)
+		}
+	}
+}
+
+func TestDecodeWrongBinaryCount(t *testing.T) {
+	for _, data := range []string{`45["file"]`, `45x-["file"]`, `45-1-["file"]`, `46-["ok"]`} {
+		if m, err := Decode(data); err == nil {
+			t.Errorf("%q: expected error, got %+v", data, *m)
+		}
+	}
+}
+
+func TestBinaryMessage(t *testing.T) {
+	data := []byte{0, 1, 0xff, 'a'}
+	m := EncodeBinary(data)
+	if !IsBinary(m) {
+		t.Fatalf("%q is not binary", m)
+	}
+	if got := DecodeBinary(m); !reflect.DeepEqual(got, data) {
+		t.Errorf("got %v, want %v", got, data)
+	}
+	if IsBinary(`42["message"]`) {
+		t.Error("text packet is binary")
+	}
+}
+
+type file struct {
+	Name    string `json:"name"`
+	Data    []byte `json:"data"`
+	Thumb   []byte `json:"thumb,omitempty"`
+	Skipped []byte `json:"-"`
+}
+
+func TestDeconstructReconstruct(t *testing.T) {
+	tests := []struct {
+		name        string
+		payload     interface{}
+		args        string
+		attachments [][]byte
+	}{
+		{"bytes", []byte{1, 2}, `{"_placeholder":true,"num":0}`, [][]byte{{1, 2}}},
+		{"struct", file{Name: "a", Data: []byte{1}, Skipped: []byte{2}},
+			`{"data":{"_placeholder":true,"num":0},"name":"a"}`, [][]byte{{1}}},
+		{"slice of structs", []file{{Name: "a", Data: []byte{1}}, {Name: "b", Data: []byte{2}, Thumb: []byte{3}}},
+			`[{"data":{"_placeholder":true,"num":0},"name":"a"},` +
+				`{"data":{"_placeholder":true,"num":1},"name":"b","thumb":{"_placeholder":true,"num":2}}]`,
+			[][]byte{{1}, {2}, {3}}},
+		{"map", map[string]interface{}{"data": []byte{1}}, `{"data":{"_placeholder":true,"num":0}}`, [][]byte{{1}}},
+		{"nil bytes", file{Name: "a"}, `{"name":"a","data":null}`, nil}, // returned as is
+		{"no binary", map[string]int{"a": 1}, `{"a":1}`, nil},
+		{"nil", nil, `null`, nil},
+	}
+
+	for _, tt := range tests {
+		payload, attachments := Deconstruct(tt.payload)
+		b, err := json.Marshal(payload)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if string(b) != tt.args {
+			t.Errorf("%s: got args %s, want %s", tt.name, b, tt.args)
+		}
+		if !reflect.DeepEqual(attachments, tt.attachments) {
+			t.Errorf("%s: got attachments %v, want %v", tt.name, attachments, tt.attachments)
+		}
+
+		args, err := Reconstruct(string(b), attachments)
+		if err != nil {
+			t.Errorf("%s: unexpected reconstruct error: %v", tt.name, err)
+			continue
+		}
+
+		want, err := json.Marshal(tt.payload) // []byte values are marshaled to base64 strings
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got, expected interface{}
+		if err := json.Unmarshal([]byte(args), &got); err != nil {
+			t.Errorf("%s: reconstructed args %s: %v", tt.name, args, err)
+			continue
+		}
+		json.Unmarshal(want, &expected)
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("%s: got reconstructed %s, want %s", tt.name, args, want)
+		}
+	}
+}
+
+func TestReconstructIntoBytes(t *testing.T) {
+	args, err := Reconstruct(`"file",{"_placeholder":true,"num":1},{"_placeholder":true,"num":0}`,
+		[][]byte{{1, 2}, {3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []interface{}
+	if err := json.Unmarshal([]byte("["+args+"]"), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	var first, second []byte
+	b, _ := json.Marshal(got[1])
+	json.Unmarshal(b, &first)
+	b, _ = json.Marshal(got[2])
+	json.Unmarshal(b, &second)
+	if !reflect.DeepEqual(first, []byte{3}) || !reflect.DeepEqual(second, []byte{1, 2}) {
+		t.Errorf("got %v and %v, want [3] and [1 2]", first, second)
+	}
+}
+
+func TestReconstructWrongPlaceholder(t *testing.T) {
+	for _, args := range []string{
+		`{"_placeholder":true,"num":1}`,
+		`{"_placeholder":true,"num":-1}`,
+		`{"_placeholder":true,"num":"0"}`,
+		`{"_placeholder":true`,
+	} {
+		if got, err := Reconstruct(args, [][]byte{{1}}); err == nil {
+			t.Errorf("%s: expected error, got %s", args, got)
+		}
+	}
+}
+
+func TestRebuild(t *testing.T) {
+	payload, attachments := Deconstruct(map[string]interface{}{"name": "a", "size": 3, "data": []byte{1, 2, 3}})
+	data, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Rebuild(data, attachments)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]interface{}{"name": "a", "size": json.Number("3"), "data": []byte{1, 2, 3}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+
+	if got, err := Rebuild([]byte(`{"a":1}`), nil); err != nil || !reflect.DeepEqual(got, json.RawMessage(`{"a":1}`)) {
+		t.Errorf("got %#v, %v, want the raw payload", got, err)
+	}
+	if _, err := Rebuild([]byte(`{"_placeholder":true,"num":1}`), [][]byte{{1}}); err == nil {
+		t.Error("expected error for the wrong placeholder")
+	}
+}
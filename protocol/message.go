@@ -5,19 +5,30 @@ const (
 	MessageTypeClose              // close connection and destroy all handle routines
 	MessageTypePing               // ping request message
 	MessageTypePong               // pong response message
-	MessageTypeEmpty              // empty message
+	MessageTypeEmpty              // empty message, connect to the namespace
 	MessageTypeEmit               // emit request, no response
 	MessageTypeAckRequest         // emit request, wait for response (ack)
 	MessageTypeAckResponse        // ack response
 	MessageTypeUpgrade            // upgrade message
 	MessageTypeBlank              // blank message
+	MessageTypeDisconnect         // disconnect from the namespace
+	MessageTypeError              // namespace error message
 )
 
+// DefaultNamespace is the name of the socket.io namespace used when no other is specified
+const DefaultNamespace = "/"
+
 // Message represents socket.io message
 type Message struct {
-	Type      int
-	AckID     int
-	EventName string
-	Args      string
-	Source    string
+	Type             int
+	AckID            int
+	Namespace        string
+	EventName        string
+	Args             string
+	Attachments      [][]byte // binary attachments of the binary event or binary ack
+	AttachmentsCount int      // amount of binary attachments following the decoded packet
+	Source           string
 }
+
+// IsDefaultNamespace returns true if the given namespace name nsp refers to the default namespace
+func IsDefaultNamespace(nsp string) bool { return nsp == "" || nsp == DefaultNamespace }
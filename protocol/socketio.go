@@ -20,9 +20,11 @@ const (
 	messageCloseClient = "41"
 	messageCommon      = "42"
 	messageACK         = "43"
+	messageError       = "44"
+	messageBinaryEvent = "45"
+	messageBinaryACK   = "46"
 	MessageUpgrade     = "5"
 	MessageBlank       = "6"
-	MessageStub        = "stub"
 )
 
 var (
@@ -40,6 +42,8 @@ func typeToText(mType int) (string, error) {
 		MessageTypeEmit:        messageCommon,
 		MessageTypeAckRequest:  messageCommon,
 		MessageTypeAckResponse: messageACK,
+		MessageTypeDisconnect:  messageCloseClient,
+		MessageTypeError:       messageError,
 	}
 	mName, exists := codesToNames[mType]
 	if !exists {
@@ -48,6 +52,14 @@ func typeToText(mType int) (string, error) {
 	return mName, nil
 }
 
+// namespacePrefix returns the packet prefix for the given namespace nsp, empty for the default one
+func namespacePrefix(nsp string) string {
+	if IsDefaultNamespace(nsp) {
+		return ""
+	}
+	return nsp + ","
+}
+
 // Encode a socket.io message m to the protocol format
 func Encode(m *Message) (string, error) {
 	result, err := typeToText(m.Type)
@@ -56,15 +68,30 @@ func Encode(m *Message) (string, error) {
 	}
 
 	switch m.Type {
-	case MessageTypeEmpty, MessageTypePing, MessageTypePong:
+	case MessageTypePing, MessageTypePong:
 		return result, nil
+	case MessageTypeOpen, MessageTypeClose:
+		return result + m.Args, nil
+	case MessageTypeEmpty, MessageTypeDisconnect, MessageTypeError:
+		return result + namespacePrefix(m.Namespace) + m.Args, nil
+	}
+
+	if len(m.Attachments) > 0 {
+		result = messageBinaryEvent
+		if m.Type == MessageTypeAckResponse {
+			result = messageBinaryACK
+		}
+		result += strconv.Itoa(len(m.Attachments)) + "-"
+	}
+
+	result += namespacePrefix(m.Namespace)
+
+	switch m.Type {
 	case MessageTypeAckRequest:
 		result += strconv.Itoa(m.AckID)
 	case MessageTypeAckResponse:
 		result += strconv.Itoa(m.AckID)
 		return result + "[" + m.Args + "]", nil
-	case MessageTypeOpen, MessageTypeClose:
-		return result + m.Args, nil
 	}
 
 	jsonMethod, err := json.Marshal(&m.EventName)
@@ -72,6 +99,9 @@ func Encode(m *Message) (string, error) {
 		return "", err
 	}
 
+	if m.Args == "" {
+		return fmt.Sprintf(`%s[%s]`, result, string(jsonMethod)), nil
+	}
 	return fmt.Sprintf(`%s[%s,%s]`, result, string(jsonMethod), m.Args), nil
 }
 
@@ -109,23 +139,34 @@ func getMessageType(data string) (int, error) {
 		case MessageEmpty:
 			return MessageTypeEmpty, nil
 		case messageCloseClient:
-			return MessageTypeClose, nil
-		case messageCommon:
+			return MessageTypeDisconnect, nil
+		case messageCommon, messageBinaryEvent:
 			return MessageTypeAckRequest, nil
-		case messageACK:
+		case messageACK, messageBinaryACK:
 			return MessageTypeAckResponse, nil
+		case messageError:
+			return MessageTypeError, nil
 		}
 	}
 	return 0, ErrorWrongMessageType
 }
 
-// getAck extracts an id of the current packet if present
-func getAck(text string) (ackId int, restText string, err error) {
-	if len(text) < 4 {
-		return 0, "", ErrorWrongPacket
+// getNamespace extracts a namespace of the current packet if present
+func getNamespace(text string) (nsp, restText string) {
+	if len(text) == 0 || text[0] != '/' {
+		return "", text
 	}
-	text = text[2:]
 
+	pos := strings.IndexByte(text, ',')
+	if pos == -1 {
+		return text, ""
+	}
+
+	return text[0:pos], text[pos+1:]
+}
+
+// getAck extracts an id of the current packet if present
+func getAck(text string) (ackId int, restText string, err error) {
 	pos := strings.IndexByte(text, '[')
 	if pos == -1 {
 		return 0, "", ErrorWrongPacket
@@ -182,29 +223,51 @@ func Decode(data string) (*Message, error) {
 	}
 
 	switch m.Type {
-	case MessageTypeUpgrade, MessageTypeClose, MessageTypePing, MessageTypePong, MessageTypeEmpty, MessageTypeBlank:
+	case MessageTypeUpgrade, MessageTypeClose, MessageTypePing, MessageTypePong, MessageTypeBlank:
 		return m, nil
 	case MessageTypeOpen:
 		m.Args = data[1:]
 		return m, nil
 	}
 
-	ack, rest, err := getAck(data)
+	rest := data[2:]
+	if prefix := data[0:2]; prefix == messageBinaryEvent || prefix == messageBinaryACK {
+		if m.AttachmentsCount, rest, err = binaryCount(rest); err != nil {
+			return nil, err
+		}
+	}
+
+	m.Namespace, rest = getNamespace(rest)
+
+	switch m.Type {
+	case MessageTypeEmpty, MessageTypeDisconnect, MessageTypeError:
+		m.Args = rest
+		return m, nil
+	}
+
+	if len(rest) < 2 {
+		return nil, ErrorWrongPacket
+	}
+
+	ack, restArgs, err := getAck(rest)
 	m.AckID = ack
 	if m.Type == MessageTypeAckResponse {
 		if err != nil {
 			return nil, err
 		}
-		m.Args = rest[1 : len(rest)-1]
+		if len(restArgs) < 2 {
+			return nil, ErrorWrongPacket
+		}
+		m.Args = restArgs[1 : len(restArgs)-1]
 		return m, nil
 	}
 
 	if err != nil {
 		m.Type = MessageTypeEmit
-		rest = data[2:]
+		restArgs = rest
 	}
 
-	m.EventName, m.Args, err = getMethod(rest)
+	m.EventName, m.Args, err = getMethod(restArgs)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,148 @@
+package protocol
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name string
+		m    Message
+		want string
+	}{
+		{"ping", Message{Type: MessageTypePing}, "2"},
+		{"pong", Message{Type: MessageTypePong}, "3"},
+		{"open", Message{Type: MessageTypeOpen, Args: `{"sid":"1"}`}, `0{"sid":"1"}`},
+		{"connect default", Message{Type: MessageTypeEmpty}, "40"},
+		{"connect default slash", Message{Type: MessageTypeEmpty, Namespace: "/"}, "40"},
+		{"connect namespace", Message{Type: MessageTypeEmpty, Namespace: "/chat"}, "40/chat,"},
+		{"connect namespace with args", Message{Type: MessageTypeEmpty, Namespace: "/chat", Args: `{"sid":"1"}`},
+			`40/chat,{"sid":"1"}`},
+		{"disconnect namespace", Message{Type: MessageTypeDisconnect, Namespace: "/chat"}, "41/chat,"},
+		{"error namespace", Message{Type: MessageTypeError, Namespace: "/chat", Args: `"denied"`}, `44/chat,"denied"`},
+		{"emit", Message{Type: MessageTypeEmit, EventName: "message", Args: `"hi"`}, `42["message","hi"]`},
+		{"emit without args", Message{Type: MessageTypeEmit, EventName: "message"}, `42["message"]`},
+		{"emit namespace", Message{Type: MessageTypeEmit, Namespace: "/chat", EventName: "message", Args: `1`},
+			`42/chat,["message",1]`},
+		{"ack request", Message{Type: MessageTypeAckRequest, AckID: 12, EventName: "get", Args: `{}`}, `4212["get",{}]`},
+		{"ack request namespace", Message{Type: MessageTypeAckRequest, Namespace: "/chat", AckID: 3, EventName: "get"},
+			`42/chat,3["get"]`},
+		{"ack response", Message{Type: MessageTypeAckResponse, AckID: 12, Args: `"ok"`}, `4312["ok"]`},
+		{"ack response namespace", Message{Type: MessageTypeAckResponse, Namespace: "/chat", AckID: 1, Args: `"ok"`},
+			`43/chat,1["ok"]`},
+	}
+
+	for _, tt := range tests {
+		got, err := Encode(&tt.m)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeWrongType(t *testing.T) {
+	if _, err := Encode(&Message{Type: MessageTypeUpgrade}); err != ErrorWrongMessageType {
+		t.Errorf("got error %v, want %v", err, ErrorWrongMessageType)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		data string
+		want Message
+	}{
+		{"2", Message{Type: MessageTypePing}},
+		{"3", Message{Type: MessageTypePong}},
+		{"5", Message{Type: MessageTypeUpgrade}},
+		{"6", Message{Type: MessageTypeBlank}},
+		{`0{"sid":"1"}`, Message{Type: MessageTypeOpen, Args: `{"sid":"1"}`}},
+		{"40", Message{Type: MessageTypeEmpty}},
+		{"40/chat", Message{Type: MessageTypeEmpty, Namespace: "/chat"}},
+		{"40/chat,", Message{Type: MessageTypeEmpty, Namespace: "/chat"}},
+		{`40/chat,{"token":"x"}`, Message{Type: MessageTypeEmpty, Namespace: "/chat", Args: `{"token":"x"}`}},
+		{"41", Message{Type: MessageTypeDisconnect}},
+		{"41/chat,", Message{Type: MessageTypeDisconnect, Namespace: "/chat"}},
+		{`44/chat,"denied"`, Message{Type: MessageTypeError, Namespace: "/chat", Args: `"denied"`}},
+		{`42["message","hi"]`, Message{Type: MessageTypeEmit, EventName: "message", Args: `"hi"`}},
+		{`42["message"]`, Message{Type: MessageTypeEmit, EventName: "message"}},
+		{`42/chat,["message",1]`, Message{Type: MessageTypeEmit, Namespace: "/chat", EventName: "message", Args: "1"}},
+		{`4212["get",{}]`, Message{Type: MessageTypeAckRequest, AckID: 12, EventName: "get", Args: "{}"}},
+		{`42/chat,3["get"]`, Message{Type: MessageTypeAckRequest, Namespace: "/chat", AckID: 3, EventName: "get"}},
+		{`4312["ok"]`, Message{Type: MessageTypeAckResponse, AckID: 12, Args: `"ok"`}},
+		{`43/chat,1["ok"]`, Message{Type: MessageTypeAckResponse, Namespace: "/chat", AckID: 1, Args: `"ok"`}},
+		{`431[]`, Message{Type: MessageTypeAckResponse, AckID: 1}},
+	}
+
+	for _, tt := range tests {
+		got, err := Decode(tt.data)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.data, err)
+			continue
+		}
+		tt.want.Source = tt.data
+		if !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("%q: got %+v, want %+v", tt.data, *got, tt.want)
+		}
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	messages := []Message{
+		{Type: MessageTypeEmpty, Namespace: "/chat"},
+		{Type: MessageTypeDisconnect, Namespace: "/chat"},
+		{Type: MessageTypeEmit, Namespace: "/chat", EventName: "message", Args: `{"text":"a,b"}`},
+		{Type: MessageTypeAckRequest, Namespace: "/chat", AckID: 7, EventName: "get", Args: `[1,2]`},
+		{Type: MessageTypeAckResponse, Namespace: "/chat", AckID: 7, Args: `"ok"`},
+		{Type: MessageTypeEmit, EventName: "message", Args: `"привет"`},
+	}
+
+	for _, m := range messages {
+		data, err := Encode(&m)
+		if err != nil {
+			t.Errorf("%+v: unexpected encode error: %v", m, err)
+			continue
+		}
+
+		got, err := Decode(data)
+		if err != nil {
+			t.Errorf("%q: unexpected decode error: %v", data, err)
+			continue
+		}
+
+		m.Source = data
+		if !reflect.DeepEqual(*got, m) {
+			t.Errorf("%q: got %+v, want %+v", data, *got, m)
+		}
+	}
+}
+
+func TestDecodeWrongPacket(t *testing.T) {
+	for _, data := range []string{"", "4", "47", "9", `42`, `42"message"`, `43["ok"]`, `43x["ok"]`} {
+		if m, err := Decode(data); err == nil {
+			t.Errorf("%q: expected error, got %+v", data, *m)
+		}
+	}
+}
+
+func TestDecodeShortPacket(t *testing.T) {
+	for _, data := range []string{`431[`, `43/x,1[`, `43/x,1`, `42[`, `42/x,[`, `45`, `451-`, `451-[`,
+		`461-1[`, `42["message`, `42["message"`, `42/chat`} {
+		if m, err := Decode(data); err == nil {
+			t.Errorf("%q: expected error, got %+v", data, *m)
+		}
+	}
+}
+
+func TestDecodeTruncatedPacket(t *testing.T) {
+	for _, data := range []string{`42/chat,12["message",{"a":[1,2]}]`, `43/chat,1["ok",{"a":1}]`,
+		`451-/chat,2["file",{"_placeholder":true,"num":0}]`} {
+		for i := range data {
+			Decode(data[:i]) // must not panic
+		}
+	}
+}
@@ -0,0 +1,463 @@
+// Package redis implements the socket.io adapter connecting several servers through redis pub/sub,
+// so broadcasts and room counts reach the channels of all servers
+package redis
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mtfelian/golang-socketio"
+	"github.com/mtfelian/golang-socketio/logging"
+	"github.com/mtfelian/golang-socketio/protocol"
+)
+
+const (
+	defaultPrefix     = "socket.io"
+	defaultTimeout    = 5 * time.Second
+	reconnectInterval = time.Second
+
+	messageBroadcast = "broadcast"
+	messageRequest   = "request"
+	messageResponse  = "response"
+
+	requestAmount = "amount"
+	requestRooms  = "rooms"
+)
+
+var (
+	ErrorBrokerClosed = errors.New("redis broker closed")
+)
+
+// Params represents the redis broker parameters
+type Params struct {
+	Address  string         // address of the redis server, host:port
+	Password string         // password to authenticate with, if not empty
+	Prefix   string         // prefix of the pub/sub channel names, "socket.io" if empty
+	Timeout  time.Duration  // timeout of redis commands and requests to other servers, 5 seconds if zero
+	Logger   logging.Logger // nil for the default logger
+}
+
+// message represents a message published by the server to the namespace pub/sub channel
+type message struct {
+	UID       string          `json:"uid"`
+	Type      string          `json:"type"`
+	ID        string          `json:"id,omitempty"`      // request id, for the requests and the responses
+	Request   string          `json:"request,omitempty"` // request type
+	Rooms     []string        `json:"rooms,omitempty"`
+	Except    []string        `json:"except,omitempty"`
+	ExceptIDs []string        `json:"except_ids,omitempty"`
+	Volatile  bool            `json:"volatile,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	Binary    [][]byte        `json:"binary,omitempty"` // binary attachments of the payload
+	Amount    int             `json:"amount,omitempty"`
+}
+
+// setPayload sets the broadcast payload of the message m, []byte values are replaced with placeholders
+// and sent aside as binary attachments
+func (m *message) setPayload(payload interface{}) error {
+	if payload == nil {
+		return nil
+	}
+
+	payload, attachments := protocol.Deconstruct(payload)
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	m.Payload, m.Binary = data, attachments
+	return nil
+}
+
+// payload returns the broadcast payload of the message m with it's binary attachments put back
+func (m *message) payload() (interface{}, error) {
+	if len(m.Payload) == 0 {
+		return nil, nil
+	}
+	return protocol.Rebuild(m.Payload, m.Binary)
+}
+
+// Broker connects the server to redis pub/sub, it's NewAdapter method should be set as the server adapter:
+//
+//	broker, err := redis.New(redis.Params{Address: "localhost:6379"})
+//	...
+//	server.SetAdapter(broker.NewAdapter)
+type Broker struct {
+	params Params
+	uid    string // unique id of the server
+
+	pub   *conn
+	pubMu sync.Mutex
+
+	sub   *conn
+	subMu sync.Mutex
+
+	adapters   map[string]*Adapter // maps pub/sub channel name to the namespace adapter
+	adaptersMu sync.RWMutex
+
+	closeC    chan struct{}
+	closeOnce sync.Once
+}
+
+// New connects the broker to the redis server with the given params
+func New(params Params) (*Broker, error) {
+	if params.Prefix == "" {
+		params.Prefix = defaultPrefix
+	}
+	if params.Timeout == 0 {
+		params.Timeout = defaultTimeout
+	}
+
+	uid := make([]byte, 8)
+	if _, err := rand.Read(uid); err != nil {
+		return nil, err
+	}
+
+	b := &Broker{
+		params:   params,
+		uid:      hex.EncodeToString(uid),
+		adapters: make(map[string]*Adapter),
+		closeC:   make(chan struct{}),
+	}
+
+	var err error
+	if b.pub, err = dial(params.Address, params.Password, params.Timeout); err != nil {
+		return nil, err
+	}
+	if b.sub, err = dial(params.Address, params.Password, params.Timeout); err != nil {
+		b.pub.close()
+		return nil, err
+	}
+
+	go b.listen()
+	return b, nil
+}
+
+// Close disconnects the broker from the redis server
+func (b *Broker) Close() error {
+	b.closeOnce.Do(func() { close(b.closeC) })
+
+	b.pubMu.Lock()
+	b.pub.close()
+	b.pubMu.Unlock()
+
+	b.subMu.Lock()
+	defer b.subMu.Unlock()
+	return b.sub.close()
+}
+
+// log returns the log entry of the broker
+func (b *Broker) log() logging.Entry { return logging.NewEntry(b.params.Logger) }
+
+// isClosed returns true if the broker is closed
+func (b *Broker) isClosed() bool {
+	select {
+	case <-b.closeC:
+		return true
+	default:
+		return false
+	}
+}
+
+// NewAdapter creates the adapter for the namespace n, it implements gosocketio.NewAdapterFunc
+func (b *Broker) NewAdapter(n *gosocketio.Namespace) gosocketio.Adapter {
+	a := &Adapter{
+		MemoryAdapter: gosocketio.NewMemoryAdapter(n),
+		broker:        b,
+		channel:       b.params.Prefix + "#" + n.Name() + "#",
+		requests:      make(map[string]chan *message),
+	}
+
+	b.adaptersMu.Lock()
+	b.adapters[a.channel] = a
+	b.adaptersMu.Unlock()
+
+	b.subMu.Lock()
+	defer b.subMu.Unlock()
+	if err := b.sub.send("SUBSCRIBE", a.channel); err != nil {
+		b.log().Warn("redis.Broker.NewAdapter() failed to subscribe", logging.Err(err))
+	}
+	return a
+}
+
+// publish the message m to the pub/sub channel, reconnecting once on failure
+func (b *Broker) publish(channel string, m *message) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = b.do("PUBLISH", channel, string(data))
+	return err
+}
+
+// numSub returns an amount of servers subscribed to the pub/sub channel
+func (b *Broker) numSub(channel string) (int, error) {
+	reply, err := b.do("PUBSUB", "NUMSUB", channel)
+	if err != nil {
+		return 0, err
+	}
+
+	items, ok := reply.([]interface{})
+	if !ok || len(items) != 2 {
+		return 0, ErrorWrongReply
+	}
+	n, ok := items[1].(int64)
+	if !ok {
+		return 0, ErrorWrongReply
+	}
+	return int(n), nil
+}
+
+// do the command with the given args over the publishing connection, reconnecting once on failure
+func (b *Broker) do(args ...string) (interface{}, error) {
+	b.pubMu.Lock()
+	defer b.pubMu.Unlock()
+
+	if b.isClosed() {
+		return nil, ErrorBrokerClosed
+	}
+
+	reply, err := b.pub.do(args...)
+	if _, ok := err.(replyError); err == nil || ok {
+		return reply, err
+	}
+
+	b.log().Debug("redis.Broker.do() reconnects", logging.Err(err))
+	b.pub.close()
+	pub, err := dial(b.params.Address, b.params.Password, b.params.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	b.pub = pub
+	return b.pub.do(args...)
+}
+
+// listen for the messages published to the subscribed channels, reconnecting on failure
+func (b *Broker) listen() {
+	for {
+		b.subMu.Lock()
+		sub := b.sub
+		b.subMu.Unlock()
+
+		sub.netConn.SetReadDeadline(time.Time{})
+		reply, err := sub.receive()
+		if err != nil {
+			if b.isClosed() {
+				return
+			}
+			b.log().Debug("redis.Broker.listen() reconnects", logging.Err(err))
+			b.resubscribe()
+			continue
+		}
+
+		items, ok := reply.([]interface{})
+		if !ok || len(items) != 3 {
+			continue
+		}
+		if kind, _ := items[0].(string); kind != "message" {
+			continue
+		}
+
+		channel, _ := items[1].(string)
+		data, _ := items[2].(string)
+		b.adaptersMu.RLock()
+		a, ok := b.adapters[channel]
+		b.adaptersMu.RUnlock()
+		if !ok {
+			continue
+		}
+
+		m := &message{}
+		if err := json.Unmarshal([]byte(data), m); err != nil {
+			b.log().Debug("redis.Broker.listen() failed to unmarshal message", logging.Err(err))
+			continue
+		}
+		if m.UID != b.uid {
+			a.process(m)
+		}
+	}
+}
+
+// resubscribe reconnects the subscribing connection and subscribes it to the channels of all the adapters
+func (b *Broker) resubscribe() {
+	for !b.isClosed() {
+		sub, err := dial(b.params.Address, b.params.Password, b.params.Timeout)
+		if err != nil {
+			time.Sleep(reconnectInterval)
+			continue
+		}
+
+		b.adaptersMu.RLock()
+		args := []string{"SUBSCRIBE"}
+		for channel := range b.adapters {
+			args = append(args, channel)
+		}
+		b.adaptersMu.RUnlock()
+
+		if err := sub.send(args...); err != nil {
+			sub.close()
+			time.Sleep(reconnectInterval)
+			continue
+		}
+
+		b.subMu.Lock()
+		b.sub.close()
+		b.sub = sub
+		b.subMu.Unlock()
+		return
+	}
+}
+
+// Adapter is the namespace adapter broadcasting to the channels of all servers connected to the broker,
+// room lists contain the local channels only
+type Adapter struct {
+	*gosocketio.MemoryAdapter
+
+	broker  *Broker
+	channel string // pub/sub channel name of the namespace
+
+	requestID  int64
+	requests   map[string]chan *message // maps request id to the responses chan
+	requestsMu sync.Mutex
+}
+
+// Broadcast the event with the given name and payload to the channels selected by opts on all servers
+func (a *Adapter) Broadcast(opts gosocketio.BroadcastOptions, name string, payload interface{}) {
+	a.MemoryAdapter.Broadcast(opts, name, payload)
+
+	m := &message{UID: a.broker.uid, Type: messageBroadcast, Rooms: opts.Rooms, Name: name,
+		Except: opts.Except, ExceptIDs: opts.ExceptIDs, Volatile: opts.Volatile}
+	if err := m.setPayload(payload); err != nil {
+		a.broker.log().Warn("redis.Adapter.Broadcast() failed to marshal payload",
+			logging.F(logging.KeyEvent, name), logging.Err(err))
+		return
+	}
+
+	if err := a.broker.publish(a.channel, m); err != nil {
+		a.broker.log().Warn("redis.Adapter.Broadcast() failed to publish", logging.F(logging.KeyEvent, name), logging.Err(err))
+	}
+}
+
+// Amount returns an amount of channels joined to the room on all servers
+func (a *Adapter) Amount(room string) int {
+	amount := a.MemoryAdapter.Amount(room)
+	for _, response := range a.request(&message{Request: requestAmount, Rooms: []string{room}}) {
+		amount += response.Amount
+	}
+	return amount
+}
+
+// CountRooms returns an amount of rooms with at least one joined channel on all servers
+func (a *Adapter) CountRooms() int {
+	rooms := make(map[string]struct{})
+	for _, room := range a.MemoryAdapter.RoomNames() {
+		rooms[room] = struct{}{}
+	}
+
+	for _, response := range a.request(&message{Request: requestRooms}) {
+		for _, room := range response.Rooms {
+			rooms[room] = struct{}{}
+		}
+	}
+	return len(rooms)
+}
+
+// request other servers with the request message m,
+// returns their responses received until the broker timeout
+func (a *Adapter) request(m *message) []*message {
+	servers, err := a.broker.numSub(a.channel)
+	if err != nil {
+		a.broker.log().Warn("redis.Adapter.request() failed to count servers", logging.Err(err))
+		return nil
+	}
+	if servers--; servers <= 0 { // the server itself is subscribed
+		return nil
+	}
+
+	id := atomic.AddInt64(&a.requestID, 1)
+	m.UID, m.Type, m.ID = a.broker.uid, messageRequest, a.broker.uid+":"+strconv.FormatInt(id, 10)
+
+	responsesC := make(chan *message, servers)
+	a.requestsMu.Lock()
+	a.requests[m.ID] = responsesC
+	a.requestsMu.Unlock()
+
+	defer func() {
+		a.requestsMu.Lock()
+		delete(a.requests, m.ID)
+		a.requestsMu.Unlock()
+	}()
+
+	if err := a.broker.publish(a.channel, m); err != nil {
+		a.broker.log().Warn("redis.Adapter.request() failed to publish", logging.Err(err))
+		return nil
+	}
+
+	responses := make([]*message, 0, servers)
+	timeout := time.After(a.broker.params.Timeout)
+	for len(responses) < servers {
+		select {
+		case response := <-responsesC:
+			responses = append(responses, response)
+		case <-timeout:
+			a.broker.log().Debug("redis.Adapter.request() timed out",
+				logging.F("responses", len(responses)), logging.F("servers", servers))
+			return responses
+		}
+	}
+	return responses
+}
+
+// process the message m published by another server
+func (a *Adapter) process(m *message) {
+	switch m.Type {
+	case messageBroadcast:
+		payload, err := m.payload()
+		if err != nil {
+			a.broker.log().Debug("redis.Adapter.process() failed to rebuild payload",
+				logging.F(logging.KeyEvent, m.Name), logging.Err(err))
+			return
+		}
+		opts := gosocketio.BroadcastOptions{Rooms: m.Rooms, Except: m.Except, ExceptIDs: m.ExceptIDs,
+			Volatile: m.Volatile}
+		a.MemoryAdapter.Broadcast(opts, m.Name, payload)
+
+	case messageRequest:
+		response := &message{UID: a.broker.uid, Type: messageResponse, ID: m.ID}
+		switch m.Request {
+		case requestAmount:
+			if len(m.Rooms) == 1 {
+				response.Amount = a.MemoryAdapter.Amount(m.Rooms[0])
+			}
+		case requestRooms:
+			response.Rooms = a.MemoryAdapter.RoomNames()
+		default:
+			return
+		}
+
+		go func() {
+			if err := a.broker.publish(a.channel, response); err != nil {
+				a.broker.log().Warn("redis.Adapter.process() failed to publish response", logging.Err(err))
+			}
+		}()
+
+	case messageResponse:
+		a.requestsMu.Lock()
+		responsesC, ok := a.requests[m.ID]
+		a.requestsMu.Unlock()
+		if !ok {
+			return
+		}
+
+		select {
+		case responsesC <- m:
+		default:
+		}
+	}
+}
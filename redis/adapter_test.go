@@ -0,0 +1,120 @@
+package redis
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mtfelian/golang-socketio"
+	"github.com/mtfelian/golang-socketio/internal/adaptertest"
+	"github.com/mtfelian/golang-socketio/logging"
+)
+
+// newTestServer starts the socket.io server connected to redis at address
+func newTestServer(t *testing.T, address, password string) *adaptertest.Server {
+	broker, err := New(Params{Address: address, Password: password, Timeout: time.Second, Logger: logging.Nop()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return adaptertest.NewServer(t, broker.NewAdapter, func() { broker.Close() })
+}
+
+// adapter returns the redis adapter of the default namespace of the server s
+func adapter(s *adaptertest.Server) *Adapter { return s.Adapter().(*Adapter) }
+
+// newTestCluster starts the fake redis and the given amount of servers connected to it
+// with the clients of adaptertest.DialClients
+func newTestCluster(t *testing.T, amount int) (*fakeRedis, []*adaptertest.Server, []*gosocketio.Client) {
+	redis := newFakeRedis(t, "secret")
+	var servers []*adaptertest.Server
+	for i := 0; i < amount; i++ {
+		servers = append(servers, newTestServer(t, redis.address(), "secret"))
+	}
+	return redis, servers, adaptertest.DialClients(t, servers)
+}
+
+func TestBroadcast(t *testing.T) {
+	_, servers, clients := newTestCluster(t, 3)
+	adaptertest.TestBroadcast(t, servers, clients)
+}
+
+func TestBroadcastBinary(t *testing.T) {
+	_, servers, clients := newTestCluster(t, 2)
+	adaptertest.TestBroadcastBinary(t, servers, clients)
+}
+
+func TestBinaryPayload(t *testing.T) {
+	m := &message{Type: messageBroadcast}
+	if err := m.setPayload(map[string]interface{}{"name": "a", "data": []byte{0, 1, 0xff}}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := &message{}
+	if err := json.Unmarshal(data, received); err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := received.payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"name": "a", "data": []byte{0, 1, 0xff}}
+	if !reflect.DeepEqual(payload, want) {
+		t.Errorf("got %#v, want %#v", payload, want)
+	}
+}
+
+func TestAmountAndCountRooms(t *testing.T) {
+	_, servers, _ := newTestCluster(t, 3)
+	servers[1].Dial(t, "a", "c")
+
+	for i, s := range servers {
+		if got := s.Amount("a"); got != 4 {
+			t.Errorf("server %d: got amount %d, want 4", i, got)
+		}
+		if got := s.Amount("c"); got != 1 {
+			t.Errorf("server %d: got amount %d, want 1", i, got)
+		}
+		if got := s.Amount("none"); got != 0 {
+			t.Errorf("server %d: got amount %d, want 0", i, got)
+		}
+		if got := s.CountRooms(); got != 3 {
+			t.Errorf("server %d: got rooms %d, want 3", i, got)
+		}
+	}
+}
+
+func TestConcurrentRequests(t *testing.T) {
+	_, servers, _ := newTestCluster(t, 2)
+	adaptertest.TestConcurrentRequests(t, servers)
+}
+
+func TestReconnect(t *testing.T) {
+	redis, servers, clients := newTestCluster(t, 2)
+	r := adaptertest.NewCounter(clients, "all")
+
+	redis.dropConnections()
+	adaptertest.WaitFor(t, func() bool { return redis.numSub(adapter(servers[0]).channel) == len(servers) })
+
+	servers[0].BroadcastToAll("all", nil) // reconnects the publishing connection
+	r.Wait(t, []int{1, 1, 1, 1})
+
+	servers[1].BroadcastTo("a", "all", nil)
+	r.Wait(t, []int{2, 1, 2, 1})
+
+	if got := servers[0].Amount("a"); got != 2 {
+		t.Errorf("got amount %d, want 2", got)
+	}
+}
+
+func TestWrongPassword(t *testing.T) {
+	redis := newFakeRedis(t, "secret")
+	if _, err := New(Params{Address: redis.address(), Password: "wrong", Timeout: time.Second}); err == nil {
+		t.Error("expected error")
+	}
+}
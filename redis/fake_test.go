@@ -0,0 +1,169 @@
+package redis
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is an in-process redis server supporting the commands used by the broker:
+// AUTH, SUBSCRIBE, PUBLISH and PUBSUB NUMSUB
+type fakeRedis struct {
+	listener net.Listener
+	password string
+
+	conns map[*conn]struct{}
+	subs  map[string]map[*conn]struct{} // maps pub/sub channel name to the subscribed connections
+	mu    sync.Mutex
+}
+
+// newFakeRedis starts the fake redis server on the loopback interface
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeRedis{
+		listener: listener,
+		password: password,
+		conns:    make(map[*conn]struct{}),
+		subs:     make(map[string]map[*conn]struct{}),
+	}
+	go f.accept()
+	t.Cleanup(f.close)
+	return f
+}
+
+// address returns the address the server listens on
+func (f *fakeRedis) address() string { return f.listener.Addr().String() }
+
+// close stops the server and drops all the connections
+func (f *fakeRedis) close() {
+	f.listener.Close()
+	f.dropConnections()
+}
+
+// dropConnections closes all the client connections, as redis restart does
+func (f *fakeRedis) dropConnections() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for c := range f.conns {
+		c.close()
+		delete(f.conns, c)
+		for _, subscribed := range f.subs {
+			delete(subscribed, c)
+		}
+	}
+}
+
+// numSub returns an amount of connections subscribed to the pub/sub channel
+func (f *fakeRedis) numSub(channel string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.subs[channel])
+}
+
+// accept the client connections
+func (f *fakeRedis) accept() {
+	for {
+		netConn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		c := &conn{netConn: netConn, reader: bufio.NewReader(netConn), writer: bufio.NewWriter(netConn),
+			timeout: time.Second}
+		f.mu.Lock()
+		f.conns[c] = struct{}{}
+		f.mu.Unlock()
+		go f.serve(c)
+	}
+}
+
+// serve the commands of the client connection c
+func (f *fakeRedis) serve(c *conn) {
+	defer func() {
+		f.mu.Lock()
+		delete(f.conns, c)
+		for _, subscribed := range f.subs {
+			delete(subscribed, c)
+		}
+		f.mu.Unlock()
+		c.close()
+	}()
+
+	authenticated := f.password == ""
+	for {
+		command, err := c.receive()
+		if err != nil {
+			return
+		}
+
+		items, _ := command.([]interface{})
+		args := make([]string, len(items))
+		for i, item := range items {
+			args[i], _ = item.(string)
+		}
+		if len(args) == 0 {
+			f.reply(c, "-ERR empty command\r\n")
+			continue
+		}
+
+		switch {
+		case args[0] == "AUTH":
+			if authenticated = len(args) == 2 && args[1] == f.password; !authenticated {
+				f.reply(c, "-WRONGPASS invalid password\r\n")
+				continue
+			}
+			f.reply(c, "+OK\r\n")
+
+		case !authenticated:
+			f.reply(c, "-NOAUTH Authentication required.\r\n")
+
+		case args[0] == "SUBSCRIBE":
+			for i, channel := range args[1:] {
+				f.mu.Lock()
+				if _, ok := f.subs[channel]; !ok {
+					f.subs[channel] = make(map[*conn]struct{})
+				}
+				f.subs[channel][c] = struct{}{}
+				f.mu.Unlock()
+				f.reply(c, "*3\r\n"+bulk("subscribe")+bulk(channel)+":"+strconv.Itoa(i+1)+"\r\n")
+			}
+
+		case args[0] == "PUBLISH" && len(args) == 3:
+			f.mu.Lock()
+			subscribed := make([]*conn, 0, len(f.subs[args[1]]))
+			for sub := range f.subs[args[1]] {
+				subscribed = append(subscribed, sub)
+			}
+			f.mu.Unlock()
+
+			for _, sub := range subscribed {
+				f.reply(sub, "*3\r\n"+bulk("message")+bulk(args[1])+bulk(args[2]))
+			}
+			f.reply(c, ":"+strconv.Itoa(len(subscribed))+"\r\n")
+
+		case args[0] == "PUBSUB" && len(args) == 3 && args[1] == "NUMSUB":
+			f.reply(c, "*2\r\n"+bulk(args[2])+":"+strconv.Itoa(f.numSub(args[2]))+"\r\n")
+
+		default:
+			f.reply(c, "-ERR unknown command\r\n")
+		}
+	}
+}
+
+// reply writes the raw RESP reply to the client connection c
+func (f *fakeRedis) reply(c *conn, reply string) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.writer.WriteString(reply)
+	c.writer.Flush()
+}
+
+// bulk returns the RESP bulk string s
+func bulk(s string) string { return "$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n" }
@@ -0,0 +1,154 @@
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrorWrongReply = errors.New("wrong redis reply")
+)
+
+// replyError represents an error reply of the redis server
+type replyError string
+
+// Error implements error interface
+func (e replyError) Error() string { return "redis: " + string(e) }
+
+// conn is a minimal redis client connection speaking RESP
+type conn struct {
+	netConn net.Conn
+	reader  *bufio.Reader
+	writer  *bufio.Writer
+	timeout time.Duration
+
+	writeMu sync.Mutex
+}
+
+// dial connects to the redis server at address and authenticates with the password if it's not empty
+func dial(address, password string, timeout time.Duration) (*conn, error) {
+	netConn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &conn{
+		netConn: netConn,
+		reader:  bufio.NewReader(netConn),
+		writer:  bufio.NewWriter(netConn),
+		timeout: timeout,
+	}
+
+	if password != "" {
+		if _, err := c.do("AUTH", password); err != nil {
+			c.close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// do sends the command with the given args and reads the reply, error replies are returned as errors
+func (c *conn) do(args ...string) (interface{}, error) {
+	if err := c.send(args...); err != nil {
+		return nil, err
+	}
+
+	c.netConn.SetReadDeadline(time.Now().Add(c.timeout))
+	reply, err := c.receive()
+	if err != nil {
+		return nil, err
+	}
+	if err, ok := reply.(replyError); ok {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// send the command with the given args as an array of bulk strings
+func (c *conn) send(args ...string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.netConn.SetWriteDeadline(time.Now().Add(c.timeout))
+	c.writer.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		c.writer.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n")
+	}
+	return c.writer.Flush()
+}
+
+// receive reads the next reply: string, int64, nil, replyError or []interface{} of them
+func (c *conn) receive() (interface{}, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, ErrorWrongReply
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return replyError(line[1:]), nil
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, ErrorWrongReply
+		}
+		return n, nil
+	case '$':
+		length, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, ErrorWrongReply
+		}
+		if length < 0 {
+			return nil, nil
+		}
+
+		buf := make([]byte, length+2)
+		if _, err := io.ReadFull(c.reader, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:length]), nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, ErrorWrongReply
+		}
+		if count < 0 {
+			return nil, nil
+		}
+
+		items := make([]interface{}, count)
+		for i := range items {
+			if items[i], err = c.receive(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, ErrorWrongReply
+}
+
+// readLine reads the CRLF terminated line without the terminator
+func (c *conn) readLine() (string, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", ErrorWrongReply
+	}
+	return line[:len(line)-2], nil
+}
+
+// close the connection
+func (c *conn) close() error { return c.netConn.Close() }
@@ -2,6 +2,7 @@ package gosocketio
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/base64"
 	"encoding/json"
@@ -17,22 +18,54 @@ import (
 	"github.com/mtfelian/golang-socketio/transport"
 )
 
+const (
+	errorInvalidNamespace   = "Invalid namespace"
+	errorServerShuttingDown = "Server is shutting down"
+	upgradeNoopInterval     = 100 * time.Millisecond
+	handshakeErrorForbidden = 4 // engine.io handshake error code for the rejected connections
+)
+
 var (
 	ErrorServerNotSet       = errors.New("server was not set")
 	ErrorConnectionNotFound = errors.New("connection not found")
 )
 
+// handshakeError represents an engine.io handshake error response body
+type handshakeError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
 // Server represents a socket.io server instance
 type Server struct {
-	*event
+	*Namespace
 	http.Handler
 
-	channels   map[string]map[*Channel]struct{} // maps room name to map of channels to an empty struct
-	rooms      map[*Channel]map[string]struct{} // maps channel to map of room names to an empty struct
-	channelsMu sync.RWMutex
+	namespaces   map[string]*Namespace // maps namespace name to namespace, except the default one
+	namespacesMu sync.RWMutex
+	newAdapter   NewAdapterFunc
+
+	middlewares   []func(c *Channel, r *http.Request) error
+	middlewaresMu sync.RWMutex
+
+	dispatcher   *dispatcher
+	dispatcherMu sync.RWMutex
+
+	queue   QueueParams
+	queueMu sync.RWMutex
+
+	onOverflood   func(c *Channel)
+	overflooded   map[*Channel]struct{} // root channels with the outgoing queue more than half full
+	overfloodedMu sync.RWMutex
 
-	sids   map[string]*Channel // maps channel id to channel
-	sidsMu sync.RWMutex
+	handlers     sync.WaitGroup // event handlers in-flight
+	shuttingDown bool
+	shutdownMu   sync.RWMutex
+
+	logger   logging.Logger // nil for the default logger
+	loggerMu sync.RWMutex
+
+	metrics *serverMetrics
 
 	websocket *transport.WebsocketTransport
 	polling   *transport.PollingTransport
@@ -41,157 +74,214 @@ type Server struct {
 // NewServer creates new socket.io server
 func NewServer() *Server {
 	s := &Server{
-		websocket: transport.DefaultWebsocketTransport(),
-		polling:   transport.DefaultPollingTransport(),
-		channels:  make(map[string]map[*Channel]struct{}),
-		rooms:     make(map[*Channel]map[string]struct{}),
-		sids:      make(map[string]*Channel),
-		event: &event{
-			onConnection:    onConnection,
-			onDisconnection: onDisconnection,
-		},
+		websocket:   transport.DefaultWebsocketTransport(),
+		polling:     transport.DefaultPollingTransport(),
+		namespaces:  make(map[string]*Namespace),
+		newAdapter:  newDefaultAdapter,
+		queue:       QueueParams{Size: queueBufferSize},
+		overflooded: make(map[*Channel]struct{}),
 	}
-	s.event.init()
+	s.Namespace = newNamespace(s, protocol.DefaultNamespace)
+	s.metrics = newServerMetrics(s)
 	return s
 }
 
-// GetChannel by it's sid
-func (s *Server) GetChannel(sid string) (*Channel, error) {
-	s.sidsMu.RLock()
-	defer s.sidsMu.RUnlock()
-
-	c, ok := s.sids[sid]
-	if !ok {
-		return nil, ErrorConnectionNotFound
+// Of returns the namespace with the given name, creating it if it does not exist yet
+func (s *Server) Of(name string) *Namespace {
+	name = namespaceName(name)
+	if name == protocol.DefaultNamespace {
+		return s.Namespace
 	}
 
-	return c, nil
-}
+	s.namespacesMu.Lock()
+	defer s.namespacesMu.Unlock()
 
-// Get amount of channels, joined to given room, using server
-func (s *Server) Amount(room string) int {
-	s.channelsMu.RLock()
-	defer s.channelsMu.RUnlock()
-	roomChannels, _ := s.channels[room]
-	return len(roomChannels)
+	n, ok := s.namespaces[name]
+	if !ok {
+		n = newNamespace(s, name)
+		s.namespaces[name] = n
+	}
+	return n
 }
 
-// List returns a list of channels joined to the given room, using server
-func (s *Server) List(room string) []*Channel {
-	s.channelsMu.RLock()
-	defer s.channelsMu.RUnlock()
+// SetAdapter sets the function creating adapters managing rooms of the server namespaces,
+// adapters of the existing namespaces are replaced, so it should be called before serving connections
+func (s *Server) SetAdapter(newAdapter NewAdapterFunc) {
+	s.namespacesMu.Lock()
+	defer s.namespacesMu.Unlock()
 
-	roomChannels, ok := s.channels[room]
-	if !ok {
-		return []*Channel{}
+	s.newAdapter = newAdapter
+	s.Namespace.setAdapter(newAdapter(s.Namespace))
+	for _, n := range s.namespaces {
+		n.setAdapter(newAdapter(n))
 	}
+}
 
-	i := 0
-	roomChannelsCopy := make([]*Channel, len(roomChannels))
-	for channel := range roomChannels {
-		roomChannelsCopy[i] = channel
-		i++
+// findNamespace returns the namespace with the given name,
+// the second parameter is true if such namespace exists.
+func (s *Server) findNamespace(name string) (*Namespace, bool) {
+	name = namespaceName(name)
+	if name == protocol.DefaultNamespace {
+		return s.Namespace, true
 	}
 
-	return roomChannelsCopy
+	s.namespacesMu.RLock()
+	defer s.namespacesMu.RUnlock()
+	n, ok := s.namespaces[name]
+	return n, ok
 }
 
-// BroadcastTo the the given room an handler with payload, using server
-func (s *Server) BroadcastTo(room, name string, payload interface{}) {
-	s.channelsMu.RLock()
-	defer s.channelsMu.RUnlock()
-
-	roomChannels, ok := s.channels[room]
+// connectNamespace connects the given root channel c to the namespace with the given name and auth payload
+func (s *Server) connectNamespace(c *Channel, name, auth string) {
+	n, ok := s.findNamespace(name)
 	if !ok {
+		c.sendConnectError(name, errorInvalidNamespace)
 		return
 	}
 
-	for cn := range roomChannels {
-		if cn.IsAlive() {
-			go cn.Emit(name, payload)
+	if n == s.Namespace { // the root channel itself is connected to the default namespace
+		if c.eio != transport.ProtocolVersion4 {
+			return
 		}
+		c.auth = auth
+		c.send(&protocol.Message{Type: protocol.MessageTypeEmpty}, c.connectPayload())
+		s.callHandler(c, OnConnection)
+		return
+	}
+
+	if _, ok := c.namespaceChannel(n.name); ok { // already connected
+		return
 	}
+
+	nc := c.addNamespaceChannel(n.name, n.event)
+	nc.namespace, nc.auth = n, auth
+	nc.send(&protocol.Message{Type: protocol.MessageTypeEmpty}, nc.connectPayload())
+	n.callHandler(nc, OnConnection)
 }
 
-// Broadcast to all clients
-func (s *Server) BroadcastToAll(method string, payload interface{}) {
-	s.sidsMu.RLock()
-	defer s.sidsMu.RUnlock()
+// sendOpenSequence to the given channel c, protocol v4 clients connect to the default namespace themselves
+func (s *Server) sendOpenSequence(c *Channel) {
+	jsonHdr, err := json.Marshal(&c.connHeader)
+	if err != nil {
+		panic(err)
+	}
+	c.outC <- []string{protocol.MustEncode(&protocol.Message{Type: protocol.MessageTypeOpen, Args: string(jsonHdr)})}
+	if c.eio != transport.ProtocolVersion4 {
+		c.outC <- []string{protocol.MustEncode(&protocol.Message{Type: protocol.MessageTypeEmpty})}
+	}
+}
 
-	for _, cn := range s.sids {
-		if cn.IsAlive() {
-			go cn.Emit(method, payload)
-		}
+// generateSid returns a new session id for the connection from the given address
+func generateSid(address string) string {
+	hash := fmt.Sprintf("%s %s %b %b", address, time.Now(), rand.Uint32(), rand.Uint32())
+	buf, sum := bytes.NewBuffer(nil), md5.Sum([]byte(hash))
+	encoder := base64.NewEncoder(base64.URLEncoding, buf)
+	encoder.Write(sum[:])
+	encoder.Close()
+	return buf.String()[:20]
+}
+
+// newChannel creates a channel for the new connection request r, the channel has no connection yet
+func (s *Server) newChannel(r *http.Request) *Channel {
+	c := &Channel{
+		address: r.RemoteAddr,
+		header:  r.Header,
+		server:  s,
+		eio:     transport.RequestProtocolVersion(r),
+		queue:   s.queueParams(),
+		connHeader: connectionHeader{
+			Sid:      generateSid(r.RemoteAddr),
+			Upgrades: []string{"websocket"},
+		},
 	}
+	c.init()
+	c.namespace, c.events = s.Namespace, s.event
+	c.setLogger(s.getLogger())
+
+	s.dispatcherMu.RLock()
+	c.dispatcher = s.dispatcher
+	s.dispatcherMu.RUnlock()
+	return c
 }
 
-// onConnection fires on connection and on connection upgrade
-func onConnection(c *Channel) {
-	c.server.sidsMu.Lock()
-	c.server.sids[c.Id()] = c
-	c.server.sidsMu.Unlock()
+// SetDispatch sets the ordered dispatch of the incoming events for the new connections,
+// see DispatchParams. By default every event is handled in it's own goroutine.
+func (s *Server) SetDispatch(params DispatchParams) {
+	s.dispatcherMu.Lock()
+	s.dispatcher = newDispatcher(params)
+	s.dispatcherMu.Unlock()
 }
 
-// onDisconnection fires on disconnection
-func onDisconnection(c *Channel) {
-	c.server.channelsMu.Lock()
-	defer c.server.channelsMu.Unlock()
+// SetLogger sets the logger of the server, it's connections and transports, nil restores the default logger
+// configured by the SIO_LL env var. Use logging.Nop() to turn the logging off. It should be called before
+// serving connections, the existing connections keep their logger.
+func (s *Server) SetLogger(l logging.Logger) {
+	s.loggerMu.Lock()
+	s.logger = l
+	s.websocket.Logger, s.polling.Logger = l, l
+	s.loggerMu.Unlock()
+}
 
-	defer func() {
-		c.server.sidsMu.Lock()
-		delete(c.server.sids, c.Id())
-		c.server.sidsMu.Unlock()
-	}()
+// getLogger returns the logger of the server, nil for the default logger
+func (s *Server) getLogger() logging.Logger {
+	s.loggerMu.RLock()
+	defer s.loggerMu.RUnlock()
+	return s.logger
+}
 
-	_, ok := c.server.rooms[c]
-	if !ok {
-		return
-	}
+// log returns the log entry of the server
+func (s *Server) log() logging.Entry { return logging.NewEntry(s.getLogger()) }
+
+// Use adds the middleware running at the handshake of every new connection before it becomes live,
+// the channel has no transport connection yet, so middlewares should not emit to it or join rooms.
+// If the middleware returns an error, the connection is rejected with HTTP 403 and the error message,
+// the following middlewares and the connection handler are not called.
+func (s *Server) Use(middleware func(c *Channel, r *http.Request) error) {
+	s.middlewaresMu.Lock()
+	s.middlewares = append(s.middlewares, middleware)
+	s.middlewaresMu.Unlock()
+}
 
-	for room := range c.server.rooms[c] {
-		if curRoom, ok := c.server.channels[room]; ok {
-			delete(curRoom, c)
-			if len(curRoom) == 0 {
-				delete(c.server.channels, room)
-			}
+// accept runs the middlewares for the new channel c created for the request r,
+// returns the first middleware error
+func (s *Server) accept(c *Channel, r *http.Request) error {
+	s.middlewaresMu.RLock()
+	middlewares := s.middlewares
+	s.middlewaresMu.RUnlock()
+
+	for _, middleware := range middlewares {
+		if err := middleware(c, r); err != nil {
+			return err
 		}
 	}
-	delete(c.server.rooms, c)
+	return nil
 }
 
-// sendOpenSequence to the given channel c
-func (s *Server) sendOpenSequence(c *Channel) {
-	jsonHdr, err := json.Marshal(&c.connHeader)
-	if err != nil {
-		panic(err)
-	}
-	c.outC <- protocol.MustEncode(&protocol.Message{Type: protocol.MessageTypeOpen, Args: string(jsonHdr)})
-	c.outC <- protocol.MustEncode(&protocol.Message{Type: protocol.MessageTypeEmpty})
+// reject the connection request with HTTP 403 and engine.io error body with the given error
+func reject(w http.ResponseWriter, err error) {
+	body, _ := json.Marshal(&handshakeError{Code: handshakeErrorForbidden, Message: err.Error()})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	w.Write(body)
 }
 
-// setupEventLoop for the given connection conn on the given address with HTTP header
-func (s *Server) setupEventLoop(conn transport.Connection, address string, header http.Header) {
+// setupEventLoop for the accepted channel c over the given connection conn
+func (s *Server) setupEventLoop(c *Channel, conn transport.Connection) {
 	interval, timeout := conn.PingParams()
-	connHeader := connectionHeader{
-		Sid: func(s string) string {
-			hash := fmt.Sprintf("%s %s %b %b", s, time.Now(), rand.Uint32(), rand.Uint32())
-			buf, sum := bytes.NewBuffer(nil), md5.Sum([]byte(hash))
-			encoder := base64.NewEncoder(base64.URLEncoding, buf)
-			encoder.Write(sum[:])
-			encoder.Close()
-			return buf.String()[:20]
-		}(address),
-		Upgrades:     []string{"websocket"},
-		PingInterval: int(interval / time.Millisecond),
-		PingTimeout:  int(timeout / time.Millisecond),
+	c.connMu.Lock()
+	c.conn = conn
+	c.updateLog()
+	c.connMu.Unlock()
+	s.metrics.connections.Inc(transport.Name(conn))
+	c.connHeader.PingInterval = int(interval / time.Millisecond)
+	c.connHeader.PingTimeout = int(timeout / time.Millisecond)
+	if c.eio == transport.ProtocolVersion4 {
+		c.connHeader.MaxPayload = s.polling.MaxPayload
 	}
 
-	c := &Channel{conn: conn, address: address, header: header, server: s, connHeader: connHeader}
-	c.init()
-
 	switch conn.(type) {
 	case *transport.PollingConnection:
-		conn.(*transport.PollingConnection).Transport.SetSid(connHeader.Sid, conn)
+		conn.(*transport.PollingConnection).Transport.SetSid(c.Id(), conn)
 	}
 
 	s.sendOpenSequence(c)
@@ -199,46 +289,164 @@ func (s *Server) setupEventLoop(conn transport.Connection, address string, heade
 	go c.inLoop(s.event)
 	go c.outLoop(s.event)
 
+	if c.eio == transport.ProtocolVersion4 { // server sends pings, OnConnection fires at CONNECT packet
+		go c.pingLoop()
+		onConnection(c)
+		return
+	}
+
 	s.callHandler(c, OnConnection)
 }
 
-// upgradeEventLoop at transport upgrade
-func (s *Server) upgradeEventLoop(conn transport.Connection, remoteAddr string, header http.Header, sid string) {
-	logging.Log().Debug("Server.upgradeEventLoop() fired")
-
-	pollingChannel, err := s.GetChannel(sid)
+// upgradeEventLoop at transport upgrade probes the new connection conn and then replaces with it
+// the polling connection of the channel with the given sid, so the channel keeps it's rooms and state
+func (s *Server) upgradeEventLoop(conn transport.Connection, sid string) {
+	c, err := s.GetChannel(sid)
 	if err != nil {
-		logging.Log().Warn("Server.upgradeEventLoop() can't find channel for session:", sid)
+		s.log().Warn("Server.upgradeEventLoop() can't find channel", logging.F(logging.KeySid, sid))
+		conn.Close()
 		return
 	}
 
-	logging.Log().Debug("Server.upgradeEventLoop() obtained a polling channel")
-	interval, timeout := conn.PingParams()
-	connHeader := connectionHeader{
-		Sid:          sid,
-		Upgrades:     []string{},
-		PingInterval: int(interval / time.Millisecond),
-		PingTimeout:  int(timeout / time.Millisecond),
+	polling, ok := c.connection().(*transport.PollingConnection)
+	if !ok {
+		c.log().Warn("Server.upgradeEventLoop() channel is already upgraded")
+		conn.Close()
+		return
 	}
 
-	c := &Channel{conn: conn, address: remoteAddr, header: header, server: s, connHeader: connHeader}
-	c.init()
-	logging.Log().Debug("Server.upgradeEventLoop() initialized a new channel")
+	c.setUpgrading(true)
+	defer c.setUpgrading(false)
 
-	go c.inLoop(s.event)
-	go c.outLoop(s.event)
+	if m, err := conn.GetMessage(); err != nil || m != protocol.MessagePingProbe {
+		c.log().Debug("Server.upgradeEventLoop() wrong probe message", logging.F(logging.KeyMessage, m), logging.Err(err))
+		conn.Close()
+		return
+	}
+	if err := conn.WriteMessage(protocol.MessagePongProbe); err != nil {
+		c.log().Debug("Server.upgradeEventLoop() failed to write probe", logging.Err(err))
+		conn.Close()
+		return
+	}
+
+	// release pending polling requests of the client until it sends the upgrade message
+	stopNoopC := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(upgradeNoopInterval)
+		defer ticker.Stop()
+		for polling.Noop(); ; {
+			select {
+			case <-ticker.C:
+				polling.Noop()
+			case <-stopNoopC:
+				return
+			}
+		}
+	}()
+
+	m, err := conn.GetMessage()
+	close(stopNoopC)
+	if err != nil || m != protocol.MessageUpgrade {
+		c.log().Debug("Server.upgradeEventLoop() wrong upgrade message", logging.F(logging.KeyMessage, m), logging.Err(err))
+		conn.Close()
+		return
+	}
+
+	if !c.upgradeConnection(polling, conn) {
+		c.log().Debug("Server.upgradeEventLoop() channel is closed or upgraded")
+		conn.Close()
+		return
+	}
+	polling.Close()
+	s.metrics.upgrades.Inc("")
+	c.log().Debug("Server.upgradeEventLoop() replaced the polling connection")
+}
+
+// handlerStarted registers the event handler in-flight,
+// returns false if the server is shutting down and the event should be dropped
+func (s *Server) handlerStarted() bool {
+	s.shutdownMu.RLock()
+	defer s.shutdownMu.RUnlock()
+
+	if s.shuttingDown {
+		return false
+	}
+	s.handlers.Add(1)
+	return true
+}
+
+// isShuttingDown returns true if the server is shutting down
+func (s *Server) isShuttingDown() bool {
+	s.shutdownMu.RLock()
+	defer s.shutdownMu.RUnlock()
+	return s.shuttingDown
+}
+
+// Shutdown gracefully shuts down the server: stops accepting new connections and upgrades,
+// waits for the event handlers in-flight, flushes the queued messages of every connection followed
+// by the close message and then closes connections firing disconnection handlers.
+// It returns when done or when ctx expires, in the latter case the remaining connections
+// are closed at once and the ctx error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shutdownMu.Lock()
+	s.shuttingDown = true
+	s.shutdownMu.Unlock()
+
+	handlersDoneC := make(chan struct{})
+	go func() {
+		s.handlers.Wait()
+		close(handlersDoneC)
+	}()
+
+	var err error
+	select {
+	case <-handlersDoneC:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	channels := s.channelsList()
+	if err == nil {
+		err = flush(ctx, channels)
+	}
 
-	logging.Log().Debug("Server.upgradeEventLoop() fired c.inLoop() and c.outLoop() in separate go-routines")
-	onConnection(c)
+	for _, c := range channels {
+		c.close(c.events, DisconnectServerShutdown)
+	}
+	return err
+}
+
+// flush queued messages of the given channels followed by the close message, waits for them to be written
+func flush(ctx context.Context, channels []*Channel) error {
+	for _, c := range channels {
+		if !c.IsAlive() {
+			continue
+		}
+
+		select {
+		case c.outC <- []string{protocol.MessageClose}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
 
-	// synchronize stubbing polling channel with receiving "2probe" message
-	<-c.upgradedC
-	pollingChannel.stub()
+	for _, c := range channels {
+		select {
+		case <-c.outDoneC:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
 }
 
 // ServeHTTP makes Server to implement http.Handler
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	session, transportName := r.URL.Query().Get("sid"), r.URL.Query().Get("transport")
+	if (session == "" || transportName == "websocket") && s.isShuttingDown() {
+		http.Error(w, errorServerShuttingDown, http.StatusServiceUnavailable)
+		return
+	}
 
 	switch transportName {
 	case "polling":
@@ -248,25 +456,37 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		c := s.newChannel(r)
+		if err := s.accept(c, r); err != nil {
+			c.log().Debug("Server.ServeHTTP() rejected a connection", logging.Err(err))
+			reject(w, err)
+			return
+		}
+
 		conn, err := s.polling.HandleConnection(w, r)
 		if err != nil {
 			return
 		}
 
-		s.setupEventLoop(conn, r.RemoteAddr, r.Header)
-		logging.Log().Debug("Server.ServeHTTP() created a PollingConnection")
+		s.setupEventLoop(c, conn)
+		c.log().Debug("Server.ServeHTTP() created a connection")
 		conn.(*transport.PollingConnection).PollingWriter(w, r)
 
 	case "websocket":
 		if session != "" {
-			logging.Log().Debug("Server.ServeHTTP() is firing s.websocket.HandleConnection() for upgrade")
 			conn, err := s.websocket.HandleConnection(w, r)
 			if err != nil {
-				logging.Log().Debug("Server.ServeHTTP() upgrade error:", err)
+				s.log().Debug("Server.ServeHTTP() failed to upgrade", logging.F(logging.KeySid, session), logging.Err(err))
 				return
 			}
-			s.upgradeEventLoop(conn, r.RemoteAddr, r.Header, session)
-			logging.Log().Debug("Server.ServeHTTP() upgraded to a WebsocketConnection")
+			s.upgradeEventLoop(conn, session)
+			return
+		}
+
+		c := s.newChannel(r)
+		if err := s.accept(c, r); err != nil {
+			c.log().Debug("Server.ServeHTTP() rejected a connection", logging.Err(err))
+			reject(w, err)
 			return
 		}
 
@@ -275,21 +495,7 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		s.setupEventLoop(conn, r.RemoteAddr, r.Header)
-		logging.Log().Debug("Server.ServeHTTP() created a WebsocketConnection")
+		s.setupEventLoop(c, conn)
+		c.log().Debug("Server.ServeHTTP() created a connection")
 	}
 }
-
-// CountChannels returns an amount of connected channels
-func (s *Server) CountChannels() int {
-	s.sidsMu.RLock()
-	defer s.sidsMu.RUnlock()
-	return len(s.sids)
-}
-
-// CountRooms returns an amount of rooms with at least one joined channel
-func (s *Server) CountRooms() int {
-	s.channelsMu.RLock()
-	defer s.channelsMu.RUnlock()
-	return len(s.channels)
-}
@@ -0,0 +1,278 @@
+package gosocketio
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mtfelian/golang-socketio/logging"
+	"github.com/mtfelian/golang-socketio/transport"
+)
+
+const waitTimeout = 3 * time.Second
+
+// serveTest starts the server s on the loopback interface and returns the port it listens on
+func serveTest(t testing.TB, s *Server) int {
+	httpServer := httptest.NewServer(s)
+	t.Cleanup(func() {
+		httpServer.CloseClientConnections()
+		httpServer.Close()
+	})
+
+	u, err := url.Parse(httpServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return port
+}
+
+// newTestServer creates the server logging nothing
+func newTestServer() *Server {
+	s := NewServer()
+	s.SetLogger(logging.Nop())
+	return s
+}
+
+// dialTest connects the client to the server listening on port over websocket, or polling if polling is true
+func dialTest(t testing.TB, port int, polling bool) *Client {
+	c, err := dialTestErr(port, polling)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+// dialTestErr connects the client like dialTest returning the dial error
+func dialTestErr(port int, polling bool) (*Client, error) { return dialTestQuery(port, polling, "") }
+
+// dialTestQuery connects the client like dialTestErr adding the query parameters to the connection url
+func dialTestQuery(port int, polling bool, query string) (*Client, error) {
+	if polling {
+		tr := transport.DefaultPollingClientTransport()
+		tr.Logger = logging.Nop()
+		return DialWithParams(AddrPolling("127.0.0.1", port, false)+query, tr, DialParams{Logger: logging.Nop()})
+	}
+
+	tr := transport.DefaultWebsocketTransport()
+	tr.Logger = logging.Nop()
+	return DialWithParams(AddrWebsocket("127.0.0.1", port, false)+query, tr, DialParams{Logger: logging.Nop()})
+}
+
+// waitFor the condition f to be true
+func waitFor(t testing.TB, f func() bool) {
+	deadline := time.Now().Add(waitTimeout)
+	for !f() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestShutdownDrainsHandlers(t *testing.T) {
+	s := newTestServer()
+	startedC, releaseC := make(chan struct{}), make(chan struct{})
+	reasonC := make(chan DisconnectReason, 1)
+	s.On("slow", func(c *Channel) {
+		close(startedC)
+		<-releaseC
+		c.Emit("done", nil)
+	})
+	s.On(OnDisconnection, func(c *Channel, reason DisconnectReason) { reasonC <- reason })
+
+	c := dialTest(t, serveTest(t, s), false)
+	var done int32
+	c.On("done", func(*Channel) { atomic.StoreInt32(&done, 1) })
+	if err := c.Emit("slow", nil); err != nil {
+		t.Fatal(err)
+	}
+	within(t, waitTimeout, func() { <-startedC })
+
+	errC := make(chan error, 1)
+	go func() { errC <- s.Shutdown(context.Background()) }()
+	select {
+	case err := <-errC:
+		t.Fatalf("returned %v with the handler in-flight", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(releaseC)
+	var err error
+	within(t, waitTimeout, func() { err = <-errC })
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reason DisconnectReason
+	within(t, waitTimeout, func() { reason = <-reasonC })
+	if reason != DisconnectServerShutdown {
+		t.Errorf("got reason %q, want %q", reason, DisconnectServerShutdown)
+	}
+	waitFor(t, func() bool { return atomic.LoadInt32(&done) == 1 }) // emitted by the handler before closing
+	waitFor(t, func() bool { return !c.IsAlive() })
+}
+
+func TestShutdownFlushesQueue(t *testing.T) {
+	const events = 300
+	s := newTestServer()
+	connectedC := make(chan *Channel, 1)
+	s.On(OnConnection, func(c *Channel) { connectedC <- c })
+	port := serveTest(t, s)
+
+	c := dialTest(t, port, false)
+	var received int32
+	c.On("seq", func(*Channel) { atomic.AddInt32(&received, 1) })
+	var sc *Channel
+	within(t, waitTimeout, func() { sc = <-connectedC })
+
+	for i := 0; i < events; i++ {
+		if err := sc.Emit("seq", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, func() bool { return atomic.LoadInt32(&received) == events })
+	waitFor(t, func() bool { return !c.IsAlive() })
+	if _, err := dialTestErr(port, false); err == nil {
+		t.Error("connected to the server shutting down")
+	}
+}
+
+func TestShutdownContextExpired(t *testing.T) {
+	s := newTestServer()
+	startedC, releaseC := make(chan struct{}), make(chan struct{})
+	t.Cleanup(func() { close(releaseC) })
+	reasonC := make(chan DisconnectReason, 1)
+	s.On("stuck", func(c *Channel) {
+		close(startedC)
+		<-releaseC
+	})
+	s.On(OnDisconnection, func(c *Channel, reason DisconnectReason) { reasonC <- reason })
+
+	c := dialTest(t, serveTest(t, s), false)
+	if err := c.Emit("stuck", nil); err != nil {
+		t.Fatal(err)
+	}
+	within(t, waitTimeout, func() { <-startedC })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	var reason DisconnectReason
+	within(t, waitTimeout, func() { reason = <-reasonC })
+	if reason != DisconnectServerShutdown {
+		t.Errorf("got reason %q, want %q", reason, DisconnectServerShutdown)
+	}
+	waitFor(t, func() bool { return !c.IsAlive() })
+}
+
+// checkRejected checks the handshake response resp is HTTP 403 with the engine.io error body
+func checkRejected(t *testing.T, resp *http.Response) {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+	if got := resp.Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("got content type %q, want application/json", got)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got handshakeError
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("got body %q: %v", body, err)
+	}
+	if want := (handshakeError{Code: handshakeErrorForbidden, Message: "wrong token"}); got != want {
+		t.Errorf("got error %+v, want %+v", got, want)
+	}
+}
+
+func TestMiddlewareReject(t *testing.T) {
+	for _, polling := range []bool{true, false} {
+		name := "websocket"
+		if polling {
+			name = "polling"
+		}
+		t.Run(name, func(t *testing.T) { testMiddlewareReject(t, polling) })
+	}
+}
+
+// testMiddlewareReject rejects the handshake without the token over polling or websocket,
+// the rejected channel is never connected
+func testMiddlewareReject(t *testing.T, polling bool) {
+	s := newTestServer()
+	var accepted, connected int32
+	s.Use(func(c *Channel, r *http.Request) error {
+		if r.URL.Query().Get("token") != "ok" {
+			return errors.New("wrong token")
+		}
+		return nil
+	})
+	s.Use(func(c *Channel, r *http.Request) error {
+		atomic.AddInt32(&accepted, 1)
+		return nil
+	})
+	s.On(OnConnection, func(c *Channel) { atomic.AddInt32(&connected, 1) })
+	port := serveTest(t, s)
+
+	if polling {
+		resp, err := http.Get(AddrPolling("127.0.0.1", port, false) + "&token=wrong")
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkRejected(t, resp)
+	} else {
+		_, resp, err := websocket.DefaultDialer.Dial(AddrWebsocket("127.0.0.1", port, false)+"&token=wrong", nil)
+		if err != websocket.ErrBadHandshake {
+			t.Fatalf("got error %v, want %v", err, websocket.ErrBadHandshake)
+		}
+		checkRejected(t, resp)
+	}
+	if _, err := dialTestQuery(port, polling, ""); err == nil {
+		t.Error("connected without the token")
+	}
+
+	time.Sleep(50 * time.Millisecond) // the rejected channels would connect
+	if got := len(s.channelsList()); got != 0 {
+		t.Errorf("got %d channels connected, want 0", got)
+	}
+	if got := atomic.LoadInt32(&connected); got != 0 {
+		t.Errorf("got %d connection handler calls, want 0", got)
+	}
+	if got := atomic.LoadInt32(&accepted); got != 0 {
+		t.Errorf("got %d calls of the middleware following the rejecting one, want 0", got)
+	}
+
+	c, err := dialTestQuery(port, polling, "&token=ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	waitFor(t, func() bool { return atomic.LoadInt32(&connected) == 1 })
+	if got := len(s.channelsList()); got != 1 {
+		t.Errorf("got %d channels connected, want 1", got)
+	}
+	if got := atomic.LoadInt32(&accepted); got != 1 {
+		t.Errorf("got %d calls of the following middleware, want 1", got)
+	}
+}
@@ -0,0 +1,165 @@
+package transport
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/mtfelian/golang-socketio/protocol"
+)
+
+const (
+	binaryBase64Prefix3 = "b4"   // prefix of base64 encoded binary message in polling payload, protocol v3
+	binaryBase64Prefix4 = "b"    // prefix of base64 encoded binary message in polling payload, protocol v4
+	recordSeparator     = "\x1e" // separates messages in polling payload, protocol v4
+)
+
+var errWrongPayload = errors.New("wrong polling payload")
+
+// binaryBase64Prefix returns a prefix of base64 encoded binary message in polling payload for the protocol version
+func binaryBase64Prefix(version int) string {
+	if version == ProtocolVersion4 {
+		return binaryBase64Prefix4
+	}
+	return binaryBase64Prefix3
+}
+
+// encodePollingMessage returns the message m suitable for the text polling payload,
+// binary messages are encoded with base64
+func encodePollingMessage(m string, version int) string {
+	if protocol.IsBinary(m) {
+		return binaryBase64Prefix(version) + base64.StdEncoding.EncodeToString(protocol.DecodeBinary(m))
+	}
+	return m
+}
+
+// decodePollingMessage returns the message m received within the text polling payload,
+// base64 encoded binary messages are decoded
+func decodePollingMessage(m string, version int) (string, error) {
+	prefix := binaryBase64Prefix(version)
+	if !strings.HasPrefix(m, prefix) {
+		return m, nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(m[len(prefix):])
+	if err != nil {
+		return "", err
+	}
+	return protocol.EncodeBinary(data), nil
+}
+
+// payloadLength returns a length of the message m as counted by JavaScript clients, in UTF-16 code units
+func payloadLength(m string) int {
+	length := 0
+	for _, r := range m {
+		if r >= 0x10000 {
+			length += 2
+		} else {
+			length++
+		}
+	}
+	return length
+}
+
+// payloadPrefix returns the prefix of the string s having the given length in UTF-16 code units,
+// the second parameter is false if s is too short
+func payloadPrefix(s string, length int) (string, bool) {
+	for i, r := range s {
+		if length <= 0 {
+			return s[:i], length == 0
+		}
+		if r >= 0x10000 {
+			length -= 2
+		} else {
+			length--
+		}
+	}
+	return s, length == 0
+}
+
+// decodePayload splits the text polling payload into messages for the protocol version
+func decodePayload(payload string, version int) ([]string, error) {
+	var messages []string
+	if version == ProtocolVersion4 {
+		messages = strings.Split(payload, recordSeparator)
+	} else {
+		for len(payload) > 0 {
+			pos := strings.IndexByte(payload, ':')
+			if pos == -1 {
+				return nil, errWrongPayload
+			}
+
+			length, err := strconv.Atoi(payload[:pos])
+			if err != nil {
+				return nil, errWrongPayload
+			}
+
+			m, ok := payloadPrefix(payload[pos+1:], length)
+			if !ok {
+				return nil, errWrongPayload
+			}
+			messages, payload = append(messages, m), payload[pos+1+len(m):]
+		}
+	}
+
+	if len(messages) == 0 {
+		return nil, errWrongPayload
+	}
+
+	for i := range messages {
+		m, err := decodePollingMessage(messages[i], version)
+		if err != nil {
+			return nil, err
+		}
+		messages[i] = m
+	}
+	return messages, nil
+}
+
+// decodeBinaryPayload splits the XHR2 binary polling payload into messages, protocol v3 only
+func decodeBinaryPayload(payload []byte) ([]string, error) {
+	var messages []string
+	for len(payload) > 0 {
+		isBinary, length, pos := payload[0] == 1, 0, 1
+		for ; pos < len(payload) && payload[pos] != 0xff; pos++ {
+			if payload[pos] > 9 {
+				return nil, errWrongPayload
+			}
+			if length = length*10 + int(payload[pos]); length > len(payload) { // the message can't be longer
+				return nil, errWrongPayload
+			}
+		}
+
+		if pos++; pos+length > len(payload) {
+			return nil, errWrongPayload
+		}
+
+		m := string(payload[pos : pos+length])
+		if isBinary && !protocol.IsBinary(m) {
+			return nil, errWrongPayload
+		}
+		messages, payload = append(messages, m), payload[pos+length:]
+	}
+
+	if len(messages) == 0 {
+		return nil, errWrongPayload
+	}
+	return messages, nil
+}
+
+// encodePayload returns the messages as the text polling payload for the protocol version
+func encodePayload(messages []string, version int) string {
+	encoded := make([]string, len(messages))
+	for i, m := range messages {
+		encoded[i] = encodePollingMessage(m, version)
+		if version != ProtocolVersion4 {
+			encoded[i] = withLength(encoded[i])
+		}
+	}
+
+	if version == ProtocolVersion4 {
+		return strings.Join(encoded, recordSeparator)
+	}
+	return strings.Join(encoded, "")
+}
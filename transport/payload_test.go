@@ -0,0 +1,185 @@
+package transport
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/mtfelian/golang-socketio/protocol"
+)
+
+func TestProtocolVersion(t *testing.T) {
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"EIO=4&transport=polling", ProtocolVersion4},
+		{"EIO=3&transport=polling", ProtocolVersion3},
+		{"transport=websocket", ProtocolVersion3},
+		{"EIO=5", ProtocolVersion3},
+	}
+
+	for _, tt := range tests {
+		query, err := url.ParseQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := protocolVersion(query); got != tt.want {
+			t.Errorf("%q: got %d, want %d", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestPayloadV4(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []string
+		payload  string
+	}{
+		{"single", []string{"2"}, "2"},
+		{"several", []string{"2", `42["message","hi"]`, "6"}, "2\x1e42[\"message\",\"hi\"]\x1e6"},
+		{"binary", []string{`451-["file",{"_placeholder":true,"num":0}]`, protocol.EncodeBinary([]byte{1, 2, 3})},
+			"451-[\"file\",{\"_placeholder\":true,\"num\":0}]\x1ebAQID"},
+		{"utf-8", []string{`42["message","привет 😀"]`}, `42["message","привет 😀"]`},
+	}
+
+	for _, tt := range tests {
+		if got := encodePayload(tt.messages, ProtocolVersion4); got != tt.payload {
+			t.Errorf("%s: encoded %q, want %q", tt.name, got, tt.payload)
+		}
+
+		got, err := decodePayload(tt.payload, ProtocolVersion4)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.messages) {
+			t.Errorf("%s: decoded %q, want %q", tt.name, got, tt.messages)
+		}
+	}
+}
+
+func TestWrongPayloadV4(t *testing.T) {
+	for _, payload := range []string{"b!!!", "2\x1eb*"} {
+		if got, err := decodePayload(payload, ProtocolVersion4); err == nil {
+			t.Errorf("%q: expected error, got %q", payload, got)
+		}
+	}
+}
+
+func TestPayloadLength(t *testing.T) {
+	tests := []struct {
+		m    string
+		want int
+	}{
+		{"", 0},
+		{"42", 2},
+		{"привет", 6}, // 2 bytes per rune in UTF-8, 1 UTF-16 code unit
+		{"€", 1},      // 3 bytes in UTF-8, 1 UTF-16 code unit
+		{"😀", 2},      // 4 bytes in UTF-8, surrogate pair in UTF-16
+		{"a😀b😀", 6},
+	}
+
+	for _, tt := range tests {
+		if got := payloadLength(tt.m); got != tt.want {
+			t.Errorf("%q: got %d, want %d", tt.m, got, tt.want)
+		}
+	}
+}
+
+func TestPayloadPrefix(t *testing.T) {
+	tests := []struct {
+		s      string
+		length int
+		want   string
+		ok     bool
+	}{
+		{"42abc", 2, "42", true},
+		{"42", 2, "42", true},
+		{"abc", 0, "", true},
+		{"😀x", 2, "😀", true},
+		{"😀x", 1, "😀", false}, // the length splits the surrogate pair
+		{"€€", 1, "€", true},
+		{"ab", 3, "ab", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := payloadPrefix(tt.s, tt.length)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%q, %d: got %q, %v, want %q, %v", tt.s, tt.length, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestPayloadV3(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []string
+		payload  string
+	}{
+		{"single", []string{"2"}, "1:2"},
+		{"several", []string{"2", `42["message","hi"]`, "6"}, `1:218:42["message","hi"]1:6`},
+		{"binary", []string{`451-["file",{"_placeholder":true,"num":0}]`, protocol.EncodeBinary([]byte{1, 2, 3})},
+			`42:451-["file",{"_placeholder":true,"num":0}]6:b4AQID`},
+		{"utf-8", []string{`42["привет"]`, "3"}, `12:42["привет"]1:3`},
+		{"surrogate pairs", []string{`42["😀😀"]`, "3"}, `10:42["😀😀"]1:3`},
+		{"colon in message", []string{`42["a:b"]`}, `9:42["a:b"]`},
+	}
+
+	for _, tt := range tests {
+		if got := encodePayload(tt.messages, ProtocolVersion3); got != tt.payload {
+			t.Errorf("%s: encoded %q, want %q", tt.name, got, tt.payload)
+		}
+
+		got, err := decodePayload(tt.payload, ProtocolVersion3)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.messages) {
+			t.Errorf("%s: decoded %q, want %q", tt.name, got, tt.messages)
+		}
+	}
+}
+
+func TestWrongPayloadV3(t *testing.T) {
+	for _, payload := range []string{
+		"",
+		"2",
+		"x:2",
+		"3:2",      // too short
+		"1:21:",    // the second message is too short
+		"1:😀",      // the length splits the surrogate pair
+		"6:b4!!!!", // wrong base64
+		"-1:2",
+	} {
+		if got, err := decodePayload(payload, ProtocolVersion3); err == nil {
+			t.Errorf("%q: expected error, got %q", payload, got)
+		}
+	}
+}
+
+func TestBinaryPayloadV3(t *testing.T) {
+	payload := []byte{0, 1, 0xff, '2', 0, 7, 0xff, '4', '2', '[', '"', 'a', '"', ']', 1, 4, 0xff, 4, 1, 2, 3}
+	want := []string{"2", "42[\"a\"]", protocol.EncodeBinary([]byte{1, 2, 3})}
+
+	got, err := decodeBinaryPayload(payload)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	for _, payload := range [][]byte{
+		{},
+		{0, 2, 0xff, '2'},  // too short
+		{0, 10, 0xff, '2'}, // wrong length digit
+		{1, 1, 0xff, '2'},  // binary message without the binary prefix
+		{0, 9, 2, 2, 3, 3, 7, 2, 0, 3, 6, 8, 5, 4, 7, 7, 5, 8, 0, 7, 0xff}, // length overflows int
+	} {
+		if got, err := decodeBinaryPayload(payload); err == nil {
+			t.Errorf("%v: expected error, got %q", payload, got)
+		}
+	}
+}
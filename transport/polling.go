@@ -4,7 +4,6 @@ import (
 	"errors"
 	"io/ioutil"
 	"net/http"
-	"strings"
 	"sync"
 	"time"
 
@@ -18,22 +17,21 @@ const (
 	PlDefaultPingTimeout    = 60 * time.Second
 	PlDefaultReceiveTimeout = 60 * time.Second
 	PlDefaultSendTimeout    = 60 * time.Second
+	PlDefaultMaxPayload     = 1000000
 
-	StopMessage     = "stop"
-	UpgradedMessage = "upgrade"
-	noError         = "0"
-
-	hijackingNotSupported = "webserver doesn't support hijacking"
+	noError = "0"
 )
 
 var (
 	errGetMessageTimeout       = errors.New("timeout waiting for the message")
 	errReceivedConnectionClose = errors.New("received connection close")
 	errWriteMessageTimeout     = errors.New("timeout waiting for write")
+	errConnectionClosed        = errors.New("connection closed")
+	errUnknownSession          = errors.New("session ID unknown")
 )
 
 // withLength returns s as a message with length
-func withLength(m string) string { return fmt.Sprintf("%d:%s", len(m), m) }
+func withLength(m string) string { return fmt.Sprintf("%d:%s", payloadLength(m), m) }
 
 // PollingTransportParams represents XHR polling transport params
 type PollingTransportParams struct {
@@ -44,21 +42,33 @@ type PollingTransportParams struct {
 type PollingConnection struct {
 	Transport  *PollingTransport
 	eventsInC  chan string
-	eventsOutC chan string
+	eventsOutC chan []string
 	errors     chan string
+	noopC      chan struct{}
+	closeC     chan struct{}
+	closeOnce  sync.Once
 	sessionID  string
+	version    int
+	log        logging.Entry
+
+	polls   int // amount of the pending polling requests
+	pollsMu sync.Mutex
 }
 
 // GetMessage waits for incoming message from the connection
 func (polling *PollingConnection) GetMessage() (string, error) {
 	select {
 	case <-time.After(polling.Transport.ReceiveTimeout):
-		logging.Log().Debug("PollingConnection.GetMessage() timed out")
+		polling.log.Debug("PollingConnection.GetMessage() timed out")
 		return "", errGetMessageTimeout
+	case <-polling.closeC:
+		return "", errConnectionClosed
 	case m := <-polling.eventsInC:
-		logging.Log().Debug("PollingConnection.GetMessage() received:", m)
+		if polling.log.Enabled(logging.LevelDebug) {
+			polling.log.Debug("PollingConnection.GetMessage() received", logging.F(logging.KeyMessage, m))
+		}
 		if m == protocol.MessageClose {
-			logging.Log().Debug("PollingConnection.GetMessage() received connection close")
+			polling.log.Debug("PollingConnection.GetMessage() received connection close")
 			return "", errReceivedConnectionClose
 		}
 		return m, nil
@@ -67,27 +77,64 @@ func (polling *PollingConnection) GetMessage() (string, error) {
 
 // WriteMessage to the connection
 func (polling *PollingConnection) WriteMessage(message string) error {
-	logging.Log().Debug("PollingConnection.WriteMessage() fired with:", message)
-	polling.eventsOutC <- message
-	logging.Log().Debug("PollingConnection.WriteMessage() written to eventsOutC:", message)
+	return polling.WritePayload([]string{message})
+}
+
+// WritePayload writes the messages to the connection within a single polling response
+func (polling *PollingConnection) WritePayload(messages []string) error {
+	select {
+	case polling.eventsOutC <- messages:
+	case <-polling.closeC:
+		return errConnectionClosed
+	}
+	if polling.log.Enabled(logging.LevelDebug) {
+		polling.log.Debug("PollingConnection.WritePayload() written to eventsOutC", logging.F(logging.KeyMessage, messages))
+	}
 	select {
 	case <-time.After(polling.Transport.SendTimeout):
 		return errWriteMessageTimeout
 	case errString := <-polling.errors:
 		if errString != noError {
-			logging.Log().Debug("PollingConnection.WriteMessage() failed to write with err:", errString)
+			polling.log.Debug("PollingConnection.WritePayload() failed to write", logging.F(logging.KeyError, errString))
 			return errors.New(errString)
 		}
 	}
 	return nil
 }
 
-// Close the polling connection and delete session
+// Noop answers the pending polling request, if any, with the noop message.
+// It's used to release the client's pending request while upgrading transport.
+func (polling *PollingConnection) Noop() {
+	select {
+	case polling.noopC <- struct{}{}:
+	default:
+	}
+}
+
+// Close the polling connection and delete session, the pending polling request is answered with the noop message
 func (polling *PollingConnection) Close() error {
-	logging.Log().Debug("PollingConnection.Close() fired for session:", polling.sessionID)
-	err := polling.WriteMessage(protocol.MessageBlank)
+	polling.log.Debug("PollingConnection.Close() fired")
+	polling.closeOnce.Do(func() { close(polling.closeC) })
 	polling.Transport.sessions.Delete(polling.sessionID)
-	return err
+	return nil
+}
+
+// Writable returns true if the client has the pending polling request to answer with the messages
+func (polling *PollingConnection) Writable() bool {
+	polling.pollsMu.Lock()
+	defer polling.pollsMu.Unlock()
+	return polling.polls > 0
+}
+
+// setPolling counts the pending polling request started or finished
+func (polling *PollingConnection) setPolling(started bool) {
+	polling.pollsMu.Lock()
+	defer polling.pollsMu.Unlock()
+	if started {
+		polling.polls++
+	} else {
+		polling.polls--
+	}
 }
 
 // PingParams returns a connection ping params
@@ -103,7 +150,6 @@ type sessions struct {
 
 // Set sets sessionID to the given connection
 func (s *sessions) Set(sessionID string, conn *PollingConnection) {
-	logging.Log().Debug("sessions.Set() fired with:", sessionID)
 	s.Lock()
 	defer s.Unlock()
 	s.m[sessionID] = conn
@@ -111,7 +157,6 @@ func (s *sessions) Set(sessionID string, conn *PollingConnection) {
 
 // Delete the sessionID
 func (s *sessions) Delete(sessionID string) {
-	logging.Log().Debug("sessions.Delete() fired with:", sessionID)
 	s.Lock()
 	defer s.Unlock()
 	delete(s.m, sessionID)
@@ -130,8 +175,10 @@ type PollingTransport struct {
 	PingTimeout    time.Duration
 	ReceiveTimeout time.Duration
 	SendTimeout    time.Duration
+	MaxPayload     int64 // maximum size of the POST request body in bytes, announced to v4 clients
 
 	Headers  http.Header
+	Logger   logging.Logger // logger of the connections, the default one if nil
 	sessions sessions
 }
 
@@ -145,15 +192,21 @@ func (t *PollingTransport) HandleConnection(w http.ResponseWriter, r *http.Reque
 	return &PollingConnection{
 		Transport:  t,
 		eventsInC:  make(chan string),
-		eventsOutC: make(chan string),
+		eventsOutC: make(chan []string),
 		errors:     make(chan string),
+		noopC:      make(chan struct{}),
+		closeC:     make(chan struct{}),
+		version:    RequestProtocolVersion(r),
+		log:        logging.NewEntry(t.Logger, logging.F(logging.KeyTransport, NamePolling)),
 	}, nil
 }
 
 // SetSid to the given sessionID and connection
 func (t *PollingTransport) SetSid(sessionID string, connection Connection) {
-	t.sessions.Set(sessionID, connection.(*PollingConnection))
-	connection.(*PollingConnection).sessionID = sessionID
+	polling := connection.(*PollingConnection)
+	t.sessions.Set(sessionID, polling)
+	polling.sessionID = sessionID
+	polling.log = polling.log.With(logging.F(logging.KeySid, sessionID))
 }
 
 // Serve is for receiving messages from client, simple decoding also here
@@ -161,33 +214,58 @@ func (t *PollingTransport) Serve(w http.ResponseWriter, r *http.Request) {
 	sessionId := r.URL.Query().Get("sid")
 	conn := t.sessions.Get(sessionId)
 	if conn == nil {
+		http.Error(w, errUnknownSession.Error(), http.StatusBadRequest)
 		return
 	}
 
 	switch r.Method {
 	case http.MethodGet:
-		logging.Log().Debug("PollingTransport.Serve() is serving GET request")
+		conn.log.Debug("PollingTransport.Serve() is serving GET request")
 		conn.PollingWriter(w, r)
 	case http.MethodPost:
-		bodyBytes, err := ioutil.ReadAll(r.Body)
+		body := r.Body
+		if t.MaxPayload > 0 {
+			body = http.MaxBytesReader(w, r.Body, t.MaxPayload)
+		}
+
+		bodyBytes, err := ioutil.ReadAll(body)
 		r.Body.Close()
 		if err != nil {
-			logging.Log().Debug("PollingTransport.Serve() error ioutil.ReadAll():", err)
+			conn.log.Debug("PollingTransport.Serve() failed to read the request body", logging.Err(err))
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
 			return
 		}
 
-		bodyString := string(bodyBytes)
-		logging.Log().Debug("PollingTransport.Serve() POST bodyString before split:", bodyString)
-		index := strings.Index(bodyString, ":")
-		body := bodyString[index+1:]
+		var messages []string
+		if r.Header.Get("Content-Type") == "application/octet-stream" {
+			conn.log.Debug("PollingTransport.Serve() POST binary payload")
+			messages, err = decodeBinaryPayload(bodyBytes)
+		} else {
+			bodyString := string(bodyBytes)
+			messages, err = decodePayload(bodyString, conn.version)
+		}
+		if err != nil {
+			conn.log.Debug("PollingTransport.Serve() failed to decode payload", logging.Err(err))
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
+		// messages are delivered before answering, so the client pausing the transport for upgrade
+		// could be sure that all of them were received
+		if conn.log.Enabled(logging.LevelDebug) {
+			conn.log.Debug("PollingTransport.Serve() POST messages", logging.F(logging.KeyMessage, messages))
+		}
+		for _, m := range messages {
+			select {
+			case conn.eventsInC <- m:
+			case <-conn.closeC:
+				http.Error(w, errConnectionClosed.Error(), http.StatusBadRequest)
+				return
+			}
+		}
 		setHeaders(w)
-
-		logging.Log().Debug("PollingTransport.Serve() POST body:", body)
 		w.Write([]byte("ok"))
-		logging.Log().Debug("PollingTransport.Serve() written POST response")
-		conn.eventsInC <- body
-		logging.Log().Debug("PollingTransport.Serve() sent to eventsInC")
+		conn.log.Debug("PollingTransport.Serve() written POST response")
 	}
 }
 
@@ -198,6 +276,7 @@ func DefaultPollingTransport() *PollingTransport {
 		PingTimeout:    PlDefaultPingTimeout,
 		ReceiveTimeout: PlDefaultReceiveTimeout,
 		SendTimeout:    PlDefaultSendTimeout,
+		MaxPayload:     PlDefaultMaxPayload,
 		sessions: sessions{
 			Mutex: sync.Mutex{},
 			m:     map[string]*PollingConnection{},
@@ -208,50 +287,32 @@ func DefaultPollingTransport() *PollingTransport {
 
 // PollingWriter for writing polling answer
 func (polling *PollingConnection) PollingWriter(w http.ResponseWriter, r *http.Request) {
+	polling.setPolling(true)
+	defer polling.setPolling(false)
+
 	setHeaders(w)
 	select {
 	case <-time.After(polling.Transport.SendTimeout):
-		logging.Log().Debug("PollingTransport.PollingWriter() timed out")
-		polling.errors <- noError
-	case message := <-polling.eventsOutC:
-		logging.Log().Debug("PollingTransport.PollingWriter() prepares to write message:", message)
-		message = withLength(message)
-		if message == withLength(protocol.MessageBlank) {
-			logging.Log().Debug("PollingTransport.PollingWriter() writing 1:6")
-
-			hj, ok := w.(http.Hijacker)
-			if !ok {
-				http.Error(w, hijackingNotSupported, http.StatusInternalServerError)
-				return
-			}
-
-			conn, buffer, err := hj.Hijack()
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-
-			defer conn.Close()
-
-			buffer.WriteString("HTTP/1.1 200 OK\r\n" +
-				"Cache-Control: no-cache, private\r\n" +
-				"Content-Length: 3\r\n" +
-				"Date: Mon, 24 Nov 2016 10:21:21 GMT\r\n\r\n")
-			buffer.WriteString(withLength(protocol.MessageBlank))
-			buffer.Flush()
-			logging.Log().Debug("PollingTransport.PollingWriter() hijack returns")
-			polling.errors <- noError
-			polling.eventsInC <- StopMessage
-		} else {
-			_, err := w.Write([]byte(message))
-			logging.Log().Debug("PollingTransport.PollingWriter() written message:", message)
-			if err != nil {
-				logging.Log().Debug("PollingTransport.PollingWriter() failed to write message with err:", err)
-				polling.errors <- err.Error()
-				return
-			}
-			polling.errors <- noError
+		polling.log.Debug("PollingTransport.PollingWriter() timed out")
+		w.Write([]byte(encodePayload([]string{protocol.MessageBlank}, polling.version)))
+	case <-polling.noopC:
+		polling.log.Debug("PollingTransport.PollingWriter() writing noop")
+		w.Write([]byte(encodePayload([]string{protocol.MessageBlank}, polling.version)))
+	case <-polling.closeC:
+		polling.log.Debug("PollingTransport.PollingWriter() writing noop at close")
+		w.Write([]byte(encodePayload([]string{protocol.MessageBlank}, polling.version)))
+	case messages := <-polling.eventsOutC:
+		message := encodePayload(messages, polling.version)
+		_, err := w.Write([]byte(message))
+		if polling.log.Enabled(logging.LevelDebug) {
+			polling.log.Debug("PollingTransport.PollingWriter() written message", logging.F(logging.KeyMessage, message))
 		}
+		if err != nil {
+			polling.log.Debug("PollingTransport.PollingWriter() failed to write message", logging.Err(err))
+			polling.errors <- err.Error()
+			return
+		}
+		polling.errors <- noError
 	}
 }
 
@@ -6,7 +6,8 @@ import (
 	"errors"
 	"io/ioutil"
 	"net/http"
-	"strings"
+	neturl "net/url"
+	"sync"
 	"time"
 
 	"github.com/mtfelian/golang-socketio/logging"
@@ -17,6 +18,8 @@ var (
 	errResponseIsNotOK       = errors.New("response body is not OK")
 	errAnswerNotOpenSequence = errors.New("not opensequence answer")
 	errAnswerNotOpenMessage  = errors.New("not openmessage answer")
+	errUpgradeNotAvailable   = errors.New("upgrade is not available")
+	errAnswerNotProbe        = errors.New("not probe answer")
 )
 
 // PollingClientConnection represents XHR polling client connection
@@ -25,47 +28,95 @@ type PollingClientConnection struct {
 	client    *http.Client
 	url       string
 	sid       string
+	version   int
+	upgrades  []string
+	received  []string // messages received within the last payload and not yet returned by GetMessage
+	log       logging.Entry
+
+	discardC    chan struct{}
+	discardOnce sync.Once
+}
+
+// discarded returns true if the connection was discarded after the transport upgrade
+func (polling *PollingClientConnection) discarded() bool {
+	select {
+	case <-polling.discardC:
+		return true
+	default:
+		return false
+	}
 }
 
-// GetMessage performs a GET request to wait for the following message
+// GetMessage returns the next message received within the last payload
+// or performs a GET request to wait for the following messages
 func (polling *PollingClientConnection) GetMessage() (string, error) {
-	logging.Log().Debug("PollingConnection.GetMessage() fired")
+	if len(polling.received) > 0 {
+		m := polling.received[0]
+		polling.received = polling.received[1:]
+		return m, nil
+	}
+
+	if polling.discarded() {
+		return "", errConnectionClosed
+	}
 
 	resp, err := polling.client.Get(polling.url)
 	if err != nil {
-		logging.Log().Debug("PollingConnection.GetMessage() error polling.client.Get():", err)
+		polling.log.Debug("PollingClientConnection.GetMessage() failed to poll", logging.Err(err))
 		return "", err
 	}
 
 	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
 	if err != nil {
-		logging.Log().Debug("PollingConnection.GetMessage() error ioutil.ReadAll():", err)
+		polling.log.Debug("PollingClientConnection.GetMessage() failed to read the response body", logging.Err(err))
 		return "", err
 	}
+	if resp.StatusCode != http.StatusOK {
+		return "", errResponseIsNotOK
+	}
 
 	bodyString := string(bodyBytes)
-	logging.Log().Debug("PollingConnection.GetMessage() bodyString:", bodyString)
-	index := strings.Index(bodyString, ":")
+	if polling.log.Enabled(logging.LevelDebug) {
+		polling.log.Debug("PollingClientConnection.GetMessage() received", logging.F(logging.KeyMessage, bodyString))
+	}
 
-	body := bodyString[index+1:]
-	return body, nil
+	messages, err := decodePayload(bodyString, polling.version)
+	if err != nil {
+		polling.log.Debug("PollingClientConnection.GetMessage() failed to decode payload", logging.Err(err))
+		return "", err
+	}
+
+	polling.received = messages[1:]
+	return messages[0], nil
 }
 
 // WriteMessage performs a POST request to send a message to server
 func (polling *PollingClientConnection) WriteMessage(m string) error {
-	mWrite := withLength(m)
-	logging.Log().Debug("PollingConnection.WriteMessage() fired, msgToWrite:", mWrite)
+	return polling.WritePayload([]string{m})
+}
+
+// WritePayload performs a POST request to send several messages to server at once
+func (polling *PollingClientConnection) WritePayload(messages []string) error {
+	if polling.discarded() {
+		return errConnectionClosed
+	}
+
+	mWrite := encodePayload(messages, polling.version)
+	if polling.log.Enabled(logging.LevelDebug) {
+		polling.log.Debug("PollingClientConnection.WritePayload() fired", logging.F(logging.KeyMessage, mWrite))
+	}
 	mJSON := []byte(mWrite)
 
 	resp, err := polling.client.Post(polling.url, "application/json", bytes.NewBuffer(mJSON))
 	if err != nil {
-		logging.Log().Debug("PollingConnection.WriteMessage() error polling.client.Post():", err)
+		polling.log.Debug("PollingClientConnection.WritePayload() failed to post", logging.Err(err))
 		return err
 	}
 
 	bodyBytes, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		logging.Log().Debug("PollingConnection.WriteMessage() error ioutil.ReadAll():", err)
+		polling.log.Debug("PollingClientConnection.WritePayload() failed to read the response body", logging.Err(err))
 		return err
 	}
 
@@ -83,6 +134,71 @@ func (polling *PollingClientConnection) Close() error {
 	return polling.WriteMessage(protocol.MessageClose)
 }
 
+// Upgrade probes the websocket upgrade transport on the connection session,
+// returns the probed websocket connection. The caller should send the upgrade message
+// through it and discard the polling connection to complete the upgrade.
+func (polling *PollingClientConnection) Upgrade() (Connection, error) {
+	if !polling.canUpgrade() {
+		return nil, errUpgradeNotAvailable
+	}
+
+	u, err := neturl.Parse(polling.url)
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := "ws"
+	if u.Scheme == "https" {
+		scheme = "wss"
+	}
+	u.Scheme = scheme
+	query := u.Query()
+	query.Set("transport", "websocket")
+	u.RawQuery = query.Encode()
+
+	conn, err := polling.transport.UpgradeTransport.Connect(u.String())
+	if err != nil {
+		polling.log.Debug("PollingClientConnection.Upgrade() failed to connect", logging.Err(err))
+		return nil, err
+	}
+
+	if err := conn.WriteMessage(protocol.MessagePingProbe); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	m, err := conn.GetMessage()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if m != protocol.MessagePongProbe {
+		conn.Close()
+		return nil, errAnswerNotProbe
+	}
+
+	return conn, nil
+}
+
+// canUpgrade returns true if the upgrade transport is set and the server allows the upgrade to websocket
+func (polling *PollingClientConnection) canUpgrade() bool {
+	if polling.transport.UpgradeTransport == nil {
+		return false
+	}
+	for _, upgrade := range polling.upgrades {
+		if upgrade == "websocket" {
+			return true
+		}
+	}
+	return false
+}
+
+// Discard stops the connection after the transport upgrade without closing the session,
+// messages already received are still returned by GetMessage
+func (polling *PollingClientConnection) Discard() {
+	polling.discardOnce.Do(func() { close(polling.discardC) })
+}
+
 // PingParams returns PingInterval and PingTimeout params
 func (polling *PollingClientConnection) PingParams() (time.Duration, time.Duration) {
 	return polling.transport.PingInterval, polling.transport.PingTimeout
@@ -95,7 +211,11 @@ type PollingClientTransport struct {
 	ReceiveTimeout time.Duration
 	SendTimeout    time.Duration
 
+	// UpgradeTransport is a websocket transport to upgrade the connections to, nil disables the upgrade
+	UpgradeTransport *WebsocketTransport
+
 	Headers  http.Header
+	Logger   logging.Logger // logger of the connections, the default one if nil
 	sessions sessions
 }
 
@@ -118,28 +238,45 @@ type openSequence struct {
 	PingTimeout  time.Duration `json:"pingTimeout"`
 }
 
-// Connect to server, perform 3 HTTP requests in connecting sequence
+// Connect to server, perform HTTP requests in connecting sequence
 func (t *PollingClientTransport) Connect(url string) (Connection, error) {
-	polling := &PollingClientConnection{transport: t, client: &http.Client{}, url: url}
+	polling := &PollingClientConnection{
+		transport: t,
+		client:    &http.Client{},
+		url:       url,
+		discardC:  make(chan struct{}),
+		log:       logging.NewEntry(t.Logger, logging.F(logging.KeyTransport, NamePolling)),
+	}
+	if u, err := neturl.Parse(url); err == nil {
+		polling.version = protocolVersion(u.Query())
+	}
 
 	resp, err := polling.client.Get(polling.url)
 	if err != nil {
-		logging.Log().Debug("PollingConnection.Connect() error polling.client.Get() 1:", err)
+		polling.log.Debug("PollingClientTransport.Connect() failed to poll", logging.Err(err))
 		return nil, err
 	}
 
 	bodyBytes, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		logging.Log().Debug("PollingConnection.Connect() error ioutil.ReadAll() 1:", err)
+		polling.log.Debug("PollingClientTransport.Connect() failed to read the response body", logging.Err(err))
 		return nil, err
 	}
 
 	resp.Body.Close()
 	bodyString := string(bodyBytes)
-	logging.Log().Debug("PollingConnection.Connect() bodyString 1:", bodyString)
+	polling.log.Debug("PollingClientTransport.Connect() received", logging.F(logging.KeyMessage, bodyString))
+	if resp.StatusCode != http.StatusOK {
+		return nil, errResponseIsNotOK
+	}
 
-	body := bodyString[strings.Index(bodyString, ":")+1:]
-	if string(body[0]) != protocol.MessageOpen {
+	messages, err := decodePayload(bodyString, polling.version)
+	if err != nil {
+		return nil, err
+	}
+
+	body := messages[0]
+	if len(body) == 0 || string(body[0]) != protocol.MessageOpen {
 		return nil, errAnswerNotOpenSequence
 	}
 
@@ -147,30 +284,25 @@ func (t *PollingClientTransport) Connect(url string) (Connection, error) {
 	var openSequence openSequence
 
 	if err := json.Unmarshal(bodyBytes2, &openSequence); err != nil {
-		logging.Log().Debug("PollingConnection.Connect() error json.Unmarshal() 1:", err)
+		polling.log.Debug("PollingClientTransport.Connect() failed to unmarshal the open message", logging.Err(err))
 		return nil, err
 	}
 
 	polling.url += "&sid=" + openSequence.Sid
-	logging.Log().Debug("PollingConnection.Connect() polling.url 1:", polling.url)
+	polling.upgrades, polling.received = openSequence.Upgrades, messages[1:]
+	polling.log = polling.log.With(logging.F(logging.KeySid, openSequence.Sid))
 
-	resp, err = polling.client.Get(polling.url)
-	if err != nil {
-		logging.Log().Debug("PollingConnection.Connect() error plc.client.Get() 2:", err)
-		return nil, err
+	if polling.version == ProtocolVersion4 { // client connects to the default namespace itself
+		return polling, nil
 	}
 
-	bodyBytes, err = ioutil.ReadAll(resp.Body)
+	// the connect message may be received within the open sequence payload
+	body, err = polling.GetMessage()
 	if err != nil {
-		logging.Log().Debug("PollingConnection.Connect() error ioutil.ReadAll() 2:", err)
+		polling.log.Debug("PollingClientTransport.Connect() failed to get the connect message", logging.Err(err))
 		return nil, err
 	}
 
-	resp.Body.Close()
-	bodyString = string(bodyBytes)
-	logging.Log().Debug("PollingConnection.Connect() bodyString 2:", bodyString)
-	body = bodyString[strings.Index(bodyString, ":")+1:]
-
 	if body != protocol.MessageEmpty {
 		return nil, errAnswerNotOpenMessage
 	}
@@ -185,5 +317,7 @@ func DefaultPollingClientTransport() *PollingClientTransport {
 		PingTimeout:    PlDefaultPingTimeout,
 		ReceiveTimeout: PlDefaultReceiveTimeout,
 		SendTimeout:    PlDefaultSendTimeout,
+
+		UpgradeTransport: DefaultWebsocketTransport(),
 	}
 }
@@ -1,10 +1,64 @@
 package transport
 
 import (
+	"net"
 	"net/http"
+	"net/url"
 	"time"
+
+	"github.com/gorilla/websocket"
 )
 
+const (
+	ProtocolVersion3 = 3 // engine.io protocol v3, socket.io v2 clients
+	ProtocolVersion4 = 4 // engine.io protocol v4, socket.io v3 and v4 clients
+
+	NamePolling   = "polling"
+	NameWebsocket = "websocket"
+)
+
+// protocolVersion returns an engine.io protocol version requested with the EIO query parameter in query
+func protocolVersion(query url.Values) int {
+	if query.Get("EIO") == "4" {
+		return ProtocolVersion4
+	}
+	return ProtocolVersion3
+}
+
+// Name returns a name of the transport of the connection conn, empty for the unknown connections
+func Name(conn Connection) string {
+	switch conn.(type) {
+	case *PollingConnection, *PollingClientConnection:
+		return NamePolling
+	case *WebsocketConnection:
+		return NameWebsocket
+	}
+	return ""
+}
+
+// IsTimeout returns true if err is returned by GetMessage() since nothing was received in time
+func IsTimeout(err error) bool {
+	if err == errGetMessageTimeout {
+		return true
+	}
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+// IsClosedByPeer returns true if err is returned by GetMessage() since the other side closed the connection,
+// the websocket closed without the close frame is reported as the abnormal closure
+func IsClosedByPeer(err error) bool {
+	return err == errReceivedConnectionClose || websocket.IsCloseError(err, websocket.CloseNormalClosure,
+		websocket.CloseGoingAway, websocket.CloseNoStatusReceived, websocket.CloseAbnormalClosure)
+}
+
+// IsNotWritten returns true if err is returned by WriteMessage() or WritePayload() of the closed connection
+// which wrote nothing
+func IsNotWritten(err error) bool { return err == errConnectionClosed }
+
+// RequestProtocolVersion returns an engine.io protocol version requested by the client with r
+func RequestProtocolVersion(r *http.Request) int { return protocolVersion(r.URL.Query()) }
+
 // Connection represents an end-point connection with transport
 type Connection interface {
 	GetMessage() (message string, err error)
@@ -13,6 +67,17 @@ type Connection interface {
 	PingParams() (interval, timeout time.Duration)
 }
 
+// PayloadWriter is implemented by connections able to write several messages at once
+type PayloadWriter interface {
+	WritePayload(messages []string) error
+}
+
+// Writable is implemented by connections which may be not writable at the moment,
+// like the polling connection between the client requests
+type Writable interface {
+	Writable() bool
+}
+
 // Transport represents a connection transport
 type Transport interface {
 	Connect(url string) (conn Connection, err error)
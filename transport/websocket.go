@@ -5,10 +5,12 @@ import (
 	"errors"
 	"io/ioutil"
 	"net/http"
+	neturl "net/url"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/mtfelian/golang-socketio/logging"
+	"github.com/mtfelian/golang-socketio/protocol"
 )
 
 const (
@@ -28,7 +30,6 @@ type WebsocketTransportParams struct {
 }
 
 var (
-	errBinaryMessage     = errors.New("binary messages are not supported")
 	errBadBuffer         = errors.New("buffer error")
 	errPacketWrong       = errors.New("wrong packet type error")
 	errMethodNotAllowed  = errors.New("method not allowed")
@@ -39,37 +40,46 @@ var (
 type WebsocketConnection struct {
 	socket    *websocket.Conn
 	transport *WebsocketTransport
+	version   int
+	log       logging.Entry
 }
 
 // GetMessage from the connection
 func (ws *WebsocketConnection) GetMessage() (string, error) {
-	logging.Log().Debug("WebsocketConnection.GetMessage() fired")
 	ws.socket.SetReadDeadline(time.Now().Add(ws.transport.ReceiveTimeout))
 
 	msgType, reader, err := ws.socket.NextReader()
 	if err != nil {
-		logging.Log().Debug("WebsocketConnection.GetMessage() ws.socket.NextReader() err:", err)
+		ws.log.Debug("WebsocketConnection.GetMessage() failed to get the next reader", logging.Err(err))
 		return "", err
 	}
 
-	// supports only text messages exchange
-	if msgType != websocket.TextMessage {
-		logging.Log().Debug("WebsocketConnection.GetMessage() returns errBinaryMessage")
-		return "", errBinaryMessage
-	}
-
 	data, err := ioutil.ReadAll(reader)
 	if err != nil {
-		logging.Log().Debug("WebsocketConnection.GetMessage() returns errBadBuffer")
+		ws.log.Debug("WebsocketConnection.GetMessage() returns errBadBuffer")
 		return "", errBadBuffer
 	}
 
 	text := string(data)
-	logging.Log().Debug("WebsocketConnection.GetMessage() text:", text)
+
+	if msgType == websocket.BinaryMessage {
+		if ws.version == ProtocolVersion4 { // binary messages have no packet type byte
+			return protocol.EncodeBinary(data), nil
+		}
+
+		// binary messages should start with the message packet type byte
+		if !protocol.IsBinary(text) {
+			ws.log.Debug("WebsocketConnection.GetMessage() returns errPacketWrong for binary message")
+			return "", errPacketWrong
+		}
+	}
+	if ws.log.Enabled(logging.LevelDebug) {
+		ws.log.Debug("WebsocketConnection.GetMessage() received", logging.F(logging.KeyMessage, text))
+	}
 
 	// empty messages are not allowed
 	if len(text) == 0 {
-		logging.Log().Debug("WebsocketConnection.GetMessage() returns errPacketWrong")
+		ws.log.Debug("WebsocketConnection.GetMessage() returns errPacketWrong")
 		return "", errPacketWrong
 	}
 
@@ -81,15 +91,25 @@ func (t *WebsocketTransport) SetSid(string, Connection) {}
 
 // WriteMessage message m into a connection
 func (ws *WebsocketConnection) WriteMessage(m string) error {
-	logging.Log().Debug("WebsocketConnection.WriteMessage() fired with:", m)
+	if ws.log.Enabled(logging.LevelDebug) {
+		ws.log.Debug("WebsocketConnection.WriteMessage() fired", logging.F(logging.KeyMessage, m))
+	}
 	ws.socket.SetWriteDeadline(time.Now().Add(ws.transport.SendTimeout))
 
-	writer, err := ws.socket.NextWriter(websocket.TextMessage)
+	msgType, data := websocket.TextMessage, []byte(m)
+	if protocol.IsBinary(m) {
+		msgType = websocket.BinaryMessage
+		if ws.version == ProtocolVersion4 { // binary messages have no packet type byte
+			data = protocol.DecodeBinary(m)
+		}
+	}
+
+	writer, err := ws.socket.NextWriter(msgType)
 	if err != nil {
 		return err
 	}
 
-	if _, err := writer.Write([]byte(m)); err != nil {
+	if _, err := writer.Write(data); err != nil {
 		return err
 	}
 
@@ -98,7 +118,7 @@ func (ws *WebsocketConnection) WriteMessage(m string) error {
 
 // Close the connection
 func (ws *WebsocketConnection) Close() error {
-	logging.Log().Debug("WebsocketConnection.Close() fired")
+	ws.log.Debug("WebsocketConnection.Close() fired")
 	return ws.socket.Close()
 }
 
@@ -109,14 +129,15 @@ func (ws *WebsocketConnection) PingParams() (time.Duration, time.Duration) {
 
 // WebsocketTransport implements websocket transport
 type WebsocketTransport struct {
-	PingInterval    time.Duration
-	PingTimeout     time.Duration
-	ReceiveTimeout  time.Duration
-	SendTimeout     time.Duration
-	
+	PingInterval   time.Duration
+	PingTimeout    time.Duration
+	ReceiveTimeout time.Duration
+	SendTimeout    time.Duration
+
 	BufferSize      int
 	Headers         http.Header
 	TLSClientConfig *tls.Config
+	Logger          logging.Logger // logger of the connections, the default one if nil
 }
 
 // Connect to the given url
@@ -126,7 +147,12 @@ func (t *WebsocketTransport) Connect(url string) (Connection, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &WebsocketConnection{socket, t}, nil
+
+	conn := &WebsocketConnection{socket: socket, transport: t, version: ProtocolVersion3, log: t.log()}
+	if u, err := neturl.Parse(url); err == nil {
+		conn.version = protocolVersion(u.Query())
+	}
+	return conn, nil
 }
 
 // HandleConnection
@@ -145,7 +171,12 @@ func (t *WebsocketTransport) HandleConnection(w http.ResponseWriter, r *http.Req
 		return nil, errHttpUpgradeFailed
 	}
 
-	return &WebsocketConnection{socket, t}, nil
+	return &WebsocketConnection{socket: socket, transport: t, version: RequestProtocolVersion(r), log: t.log()}, nil
+}
+
+// log returns the log entry of the new connection
+func (t *WebsocketTransport) log() logging.Entry {
+	return logging.NewEntry(t.Logger, logging.F(logging.KeyTransport, NameWebsocket))
 }
 
 // Serve does nothing here. Websocket connection does not require any additional processing
@@ -0,0 +1,152 @@
+package gosocketio
+
+import (
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mtfelian/golang-socketio/transport"
+)
+
+// deliveries counts the events received by their sequence numbers
+type deliveries struct {
+	counts map[int]int
+	mu     sync.Mutex
+}
+
+// add the event with the sequence number i
+func (d *deliveries) add(i int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.counts == nil {
+		d.counts = make(map[int]int)
+	}
+	d.counts[i]++
+}
+
+// wait until every event with the sequence number below n is received once and no more events arrive for a while
+func (d *deliveries) wait(t *testing.T, name string, n int) {
+	want := make(map[int]int, n)
+	for i := 0; i < n; i++ {
+		want[i] = 1
+	}
+	get := func() map[int]int {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		got := make(map[int]int, len(d.counts))
+		for i, count := range d.counts {
+			got[i] = count
+		}
+		return got
+	}
+
+	deadline := time.Now().Add(waitTimeout)
+	for !reflect.DeepEqual(get(), want) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(30 * time.Millisecond) // duplicates arrive
+	got := get()
+	for i := 0; i < n; i++ {
+		if got[i] != 1 {
+			t.Errorf("%s: event %d received %d times", name, i, got[i])
+		}
+	}
+	if len(got) != n {
+		t.Errorf("%s: got %d events, want %d", name, len(got), n)
+	}
+}
+
+// isWebsocket returns true if the channel c is upgraded to websocket
+func isWebsocket(c *Channel) bool { return transport.Name(c.connection()) == transport.NameWebsocket }
+
+func TestUpgradeUnderLoad(t *testing.T) {
+	runs := 30 // the upgrade overlaps the write in-flight in some of them
+	if testing.Short() {
+		runs = 3
+	}
+	for i := 0; i < runs && !t.Failed(); i++ {
+		testUpgradeUnderLoad(t)
+	}
+}
+
+// testUpgradeUnderLoad upgrades the polling client to websocket while both sides emit events,
+// every event is delivered exactly once and the channel keeps it's rooms and acks
+func testUpgradeUnderLoad(t *testing.T) {
+	const events = 100 // emitted at least by every side
+	s := newTestServer()
+	connectedC := make(chan *Channel, 1)
+	s.On(OnConnection, func(c *Channel) {
+		c.Join("room")
+		connectedC <- c
+	})
+	var up deliveries
+	s.On("up", func(c *Channel, i int) { up.add(i) })
+	s.On("echo", func(c *Channel, i int) int { return i })
+
+	c := dialTest(t, serveTest(t, s), true)
+	var down deliveries
+	c.On("down", func(c *Channel, i int) { down.add(i) })
+	var sc *Channel
+	within(t, waitTimeout, func() { sc = <-connectedC })
+
+	ack := func(i int) {
+		if got, err := c.Ack("echo", i, waitTimeout); err != nil || got != strconv.Itoa(i) {
+			t.Errorf("got ack %q, error %v, want %d", got, err, i)
+		}
+	}
+
+	// both sides emit until the channel is upgraded, so the upgrade overlaps the writes in-flight,
+	// pacing the events not to overflow the queues while polling
+	emitting := func(n int, c *Channel) bool {
+		if n < events {
+			return true
+		}
+		if isWebsocket(c) || !c.IsAlive() {
+			return false
+		}
+		time.Sleep(100 * time.Microsecond)
+		return true
+	}
+	var (
+		wg                   sync.WaitGroup
+		upEvents, downEvents int
+	)
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for ; emitting(upEvents, c.Channel); upEvents++ {
+			if err := c.Emit("up", upEvents); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for ; emitting(downEvents, sc); downEvents++ {
+			s.BroadcastTo("room", "down", downEvents)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			ack(i)
+		}
+	}()
+	wg.Wait()
+
+	if !isWebsocket(c.Channel) || !isWebsocket(sc) {
+		t.Fatal("not upgraded")
+	}
+	up.wait(t, "up", upEvents)
+	down.wait(t, "down", downEvents)
+
+	if got := s.Amount("room"); got != 1 {
+		t.Errorf("got %d channels in the room after the upgrade, want 1", got)
+	}
+	s.BroadcastTo("room", "down", downEvents)
+	down.wait(t, "down", downEvents+1)
+	ack(events)
+}
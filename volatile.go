@@ -0,0 +1,94 @@
+package gosocketio
+
+import (
+	"github.com/mtfelian/golang-socketio/transport"
+)
+
+// ChannelStats represents the channel statistics
+type ChannelStats struct {
+	VolatileDropped int // amount of volatile packets dropped since the channel was not writable
+}
+
+// VolatileEmitter emits volatile events to the channel, see Channel.Volatile
+type VolatileEmitter struct {
+	c *Channel
+}
+
+// Volatile returns the emitter of the volatile events which are dropped instead of queueing
+// if the channel is not writable at the moment: it's outgoing queue is above the volatile threshold,
+// the transport is being upgraded or the polling client has no pending request
+func (c *Channel) Volatile() *VolatileEmitter { return &VolatileEmitter{c: c} }
+
+// Emit the volatile event with the given name and payload, returns nil if the event is dropped
+func (v *VolatileEmitter) Emit(name string, payload interface{}) error {
+	c := v.c
+	packets, err := c.encodeEvent(name, payload)
+	if err != nil {
+		return err
+	}
+
+	if c.enqueueVolatile(packets) {
+		c.countSent(name, packets)
+	}
+	return nil
+}
+
+// Volatile returns the operator broadcasting volatile events, they are dropped for the channels
+// not writable at the moment
+func (o *BroadcastOperator) Volatile() *BroadcastOperator {
+	opts := o.opts
+	opts.Volatile = true
+	return &BroadcastOperator{namespace: o.namespace, opts: opts}
+}
+
+// Volatile returns the operator broadcasting volatile events to all the channels of the namespace
+func (n *Namespace) Volatile() *BroadcastOperator { return newBroadcastOperator(n).Volatile() }
+
+// Stats returns the channel statistics
+func (c *Channel) Stats() ChannelStats {
+	return ChannelStats{VolatileDropped: c.volatileDropped.Get()}
+}
+
+// writable returns true if the volatile packets may be queued to the channel
+func (c *Channel) writable() bool {
+	if len(c.outC) > c.queue.volatileThreshold() {
+		return false
+	}
+
+	root := c.root()
+	root.connMu.RLock()
+	conn, upgrading := root.conn, root.upgrading
+	root.connMu.RUnlock()
+
+	if upgrading {
+		return false
+	}
+	if w, ok := conn.(transport.Writable); ok && !w.Writable() {
+		return false
+	}
+	return true
+}
+
+// enqueueVolatile queues the volatile packets to the channel c if it's writable, otherwise drops them,
+// returns true if the packets are queued
+func (c *Channel) enqueueVolatile(packets []string) bool {
+	if !c.writable() {
+		c.volatileDropped.Inc()
+		return false
+	}
+
+	select {
+	case c.outC <- packets:
+		return true
+	default:
+		c.volatileDropped.Inc()
+		return false
+	}
+}
+
+// setUpgrading marks the channel transport as being upgraded or not
+func (c *Channel) setUpgrading(upgrading bool) {
+	c.connMu.Lock()
+	c.upgrading = upgrading
+	c.connMu.Unlock()
+}